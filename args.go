@@ -0,0 +1,77 @@
+package queries
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// MergeArgs combines several argument maps into a new one. Later maps take
+// precedence over earlier ones and nil maps are skipped.
+func MergeArgs(maps ...map[string]interface{}) map[string]interface{} {
+	size := 0
+	for _, m := range maps {
+		size += len(m)
+	}
+
+	merged := make(map[string]interface{}, size)
+	for _, m := range maps {
+		for k, v := range m {
+			merged[k] = v
+		}
+	}
+
+	return merged
+}
+
+// BindFromEnv builds an argument map from environment variables named
+// PREFIX_PARAM (uppercased, non-alphanumeric characters replaced by "_").
+// Values are converted to int64, float64 or bool when they parse as such
+// and kept as strings otherwise. Unset variables are left out of the map.
+func BindFromEnv(prefix string, params []string) map[string]interface{} {
+	args := make(map[string]interface{})
+
+	for _, param := range params {
+		value, ok := os.LookupEnv(envName(prefix, param))
+		if !ok {
+			continue
+		}
+
+		args[param] = inferValue(value)
+	}
+
+	return args
+}
+
+func envName(prefix, param string) string {
+	name := param
+	if prefix != "" {
+		name = prefix + "_" + param
+	}
+
+	return strings.Map(func(r rune) rune {
+		if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+			return unicode.ToUpper(r)
+		}
+		return '_'
+	}, name)
+}
+
+func inferValue(value string) interface{} {
+	if i, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return i
+	}
+	if f, err := strconv.ParseFloat(value, 64); err == nil {
+		return f
+	}
+
+	switch strings.ToLower(value) {
+	case "true":
+		return true
+	case "false":
+		return false
+	}
+
+	return value
+}
@@ -0,0 +1,93 @@
+package queries
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMergeArgs(t *testing.T) {
+	testCases := []struct {
+		name     string
+		maps     []map[string]interface{}
+		expected map[string]interface{}
+	}{
+		{
+			name:     "no maps",
+			maps:     nil,
+			expected: map[string]interface{}{},
+		},
+		{
+			name: "later overrides earlier",
+			maps: []map[string]interface{}{
+				{"user_id": 1, "status": "active"},
+				{"user_id": 2},
+			},
+			expected: map[string]interface{}{"user_id": 2, "status": "active"},
+		},
+		{
+			name: "nil maps are skipped",
+			maps: []map[string]interface{}{
+				nil,
+				{"tenant": "acme"},
+				nil,
+			},
+			expected: map[string]interface{}{"tenant": "acme"},
+		},
+		{
+			name: "explicit nil value overrides",
+			maps: []map[string]interface{}{
+				{"status": "active"},
+				{"status": nil},
+			},
+			expected: map[string]interface{}{"status": nil},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			result := MergeArgs(tc.maps...)
+			if !reflect.DeepEqual(result, tc.expected) {
+				t.Errorf("MergeArgs: got %v, expected %v", result, tc.expected)
+			}
+		})
+	}
+}
+
+func TestMergeArgsDoesNotModifyInputs(t *testing.T) {
+	defaults := map[string]interface{}{"status": "active"}
+	MergeArgs(defaults, map[string]interface{}{"status": "deleted"})
+
+	if defaults["status"] != "active" {
+		t.Errorf("input map was modified: %v", defaults)
+	}
+}
+
+func TestBindFromEnv(t *testing.T) {
+	t.Setenv("SEED_USER_ID", "42")
+	t.Setenv("SEED_EMAIL", "user@example.com")
+	t.Setenv("SEED_ACTIVE", "true")
+	t.Setenv("SEED_RATIO", "0.5")
+	t.Setenv("SEED_TENANT_NAME", "acme")
+
+	args := BindFromEnv("seed", []string{"user_id", "email", "active", "ratio", "tenant-name", "missing"})
+
+	expected := map[string]interface{}{
+		"user_id":     int64(42),
+		"email":       "user@example.com",
+		"active":      true,
+		"ratio":       0.5,
+		"tenant-name": "acme",
+	}
+	if !reflect.DeepEqual(args, expected) {
+		t.Errorf("BindFromEnv: got %v, expected %v", args, expected)
+	}
+}
+
+func TestBindFromEnvWithoutPrefix(t *testing.T) {
+	t.Setenv("USER_ID", "7")
+
+	args := BindFromEnv("", []string{"user_id"})
+	if args["user_id"] != int64(7) {
+		t.Errorf("BindFromEnv: got %v", args)
+	}
+}
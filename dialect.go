@@ -0,0 +1,157 @@
+package queries
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Dialect describes how a database driver expects bind placeholders
+type Dialect struct {
+	Name string
+	// Placeholder renders the marker for the n-th argument, starting at 1
+	Placeholder func(n int) string
+	// Positional dialects bind one argument per placeholder, so a
+	// parameter used twice is passed twice
+	Positional bool
+}
+
+var (
+	DialectPostgres = Dialect{
+		Name:        "postgres",
+		Placeholder: func(n int) string { return "$" + strconv.Itoa(n) },
+	}
+	DialectMySQL = Dialect{
+		Name:        "mysql",
+		Placeholder: func(int) string { return "?" },
+		Positional:  true,
+	}
+	DialectSQLite = Dialect{
+		Name:        "sqlite",
+		Placeholder: func(int) string { return "?" },
+		Positional:  true,
+	}
+	DialectSQLServer = Dialect{
+		Name:        "sqlserver",
+		Placeholder: func(n int) string { return fmt.Sprintf("@p%d", n) },
+	}
+	DialectOracle = Dialect{
+		Name:        "oracle",
+		Placeholder: func(n int) string { return fmt.Sprintf(":%d", n) },
+	}
+)
+
+func (d Dialect) String() string {
+	return d.Name
+}
+
+// QueryFor returns the ordinal query with placeholders rendered for the
+// dialect. Use PrepareFor with the same dialect to build the arguments.
+func (q *Query) QueryFor(d Dialect) string {
+	return fmt.Sprintf("-- name: %s\n%s", q.header, q.render(d))
+}
+
+// SQLWithQuestionMarks returns SQL with a "?" for every parameter
+// occurrence, as expected by MySQL and sqlx.In. A parameter used twice gets
+// two markers, so the arguments come from PrepareFor(DialectMySQL, args).
+// Queries using "?" as an operator, e.g. the jsonb "?" key test, are
+// rejected as the result would be ambiguous.
+func (q *Query) SQLWithQuestionMarks() (string, error) {
+	masked := []byte(maskLiterals(stripSQLComments(q.Raw)))
+	for _, span := range q.spans {
+		for i := span.start; i < span.end; i++ {
+			masked[i] = ' '
+		}
+	}
+	if bytes.IndexByte(masked, '?') >= 0 {
+		return "", fmt.Errorf("Query '%s' uses the ? operator and can't be written with ? placeholders", q.Name)
+	}
+
+	return q.render(DialectMySQL), nil
+}
+
+func (q *Query) render(d Dialect) string {
+	if d.Placeholder == nil {
+		d = DialectPostgres
+	}
+
+	query := replaceSpans(q.Raw, q.spans, func(i int, span paramSpan) string {
+		if d.Positional {
+			return d.Placeholder(i + 1)
+		}
+		return d.Placeholder(span.ordinal)
+	})
+	if q.stripComments {
+		query = removeSQLComments(query)
+	}
+
+	return query
+}
+
+// ValidateDialects renders every query for each dialect and checks the
+// result without a database: every parameter has a distinct non-empty
+// placeholder, the placeholder count matches the prepared arguments and no
+// :name parameter is left over. Errors are returned sorted by query name.
+func (s *QueryStore) ValidateDialects(dialects ...Dialect) []error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var errs []error
+
+	for _, name := range s.sortedNames() {
+		q := s.queries[name]
+		for _, d := range dialects {
+			if err := q.validateDialect(d); err != nil {
+				errs = append(errs, fmt.Errorf("Query '%s' (%s): %v", name, d, err))
+			}
+		}
+	}
+
+	return errs
+}
+
+func (q *Query) validateDialect(d Dialect) error {
+	if d.Placeholder == nil {
+		return fmt.Errorf("dialect has no placeholder renderer")
+	}
+
+	rendered := maskLiterals(stripSQLComments(q.render(d)))
+
+	// a positional dialect consumes one argument per placeholder
+	markers := map[string]int{}
+	expected := len(q.ordinalNames())
+	if d.Positional {
+		marker := d.Placeholder(1)
+		markers[marker] = len(q.spans)
+		expected = len(q.spans)
+	} else {
+		for _, span := range q.spans {
+			markers[d.Placeholder(span.ordinal)]++
+		}
+		if len(markers) != len(q.Mapping) {
+			return fmt.Errorf("%d parameters rendered as %d distinct placeholders", len(q.Mapping), len(markers))
+		}
+	}
+
+	if prepared := len(q.PrepareFor(d, nil)); prepared != expected {
+		return fmt.Errorf("%d placeholders but %d prepared arguments", expected, prepared)
+	}
+
+	for marker, count := range markers {
+		if marker == "" {
+			return fmt.Errorf("empty placeholder")
+		}
+		if found := strings.Count(rendered, marker); found < count {
+			return fmt.Errorf("placeholder %s found %d times, expected %d", marker, found, count)
+		}
+	}
+
+	for _, span := range findNamedParams(rendered, nil) {
+		if _, ok := q.Mapping[span.name]; ok {
+			return fmt.Errorf("parameter :%s was not rendered", span.name)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,180 @@
+package queries
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestQueryFor(t *testing.T) {
+	q := mustNewQuery(t, "get-user", "SELECT * FROM users WHERE id = :id AND name = :name OR alias = :name")
+	args := map[string]interface{}{"id": 1, "name": "joe"}
+
+	testCases := []struct {
+		dialect      Dialect
+		expected     string
+		expectedArgs []interface{}
+	}{
+		{
+			dialect:      DialectPostgres,
+			expected:     "-- name: get-user\nSELECT * FROM users WHERE id = $1 AND name = $2 OR alias = $2",
+			expectedArgs: []interface{}{1, "joe"},
+		},
+		{
+			dialect:      DialectMySQL,
+			expected:     "-- name: get-user\nSELECT * FROM users WHERE id = ? AND name = ? OR alias = ?",
+			expectedArgs: []interface{}{1, "joe", "joe"},
+		},
+		{
+			dialect:      DialectSQLite,
+			expected:     "-- name: get-user\nSELECT * FROM users WHERE id = ? AND name = ? OR alias = ?",
+			expectedArgs: []interface{}{1, "joe", "joe"},
+		},
+		{
+			dialect:      DialectSQLServer,
+			expected:     "-- name: get-user\nSELECT * FROM users WHERE id = @p1 AND name = @p2 OR alias = @p2",
+			expectedArgs: []interface{}{1, "joe"},
+		},
+		{
+			dialect:      DialectOracle,
+			expected:     "-- name: get-user\nSELECT * FROM users WHERE id = :1 AND name = :2 OR alias = :2",
+			expectedArgs: []interface{}{1, "joe"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.dialect.Name, func(t *testing.T) {
+			if result := q.QueryFor(tc.dialect); result != tc.expected {
+				t.Errorf("QueryFor: got %q, expected %q", result, tc.expected)
+			}
+			if prepared := q.PrepareFor(tc.dialect, args); !reflect.DeepEqual(prepared, tc.expectedArgs) {
+				t.Errorf("PrepareFor: got %v, expected %v", prepared, tc.expectedArgs)
+			}
+		})
+	}
+
+	if q.QueryFor(DialectPostgres) != q.OrdinalQuery {
+		t.Errorf("QueryFor(DialectPostgres) differs from OrdinalQuery")
+	}
+}
+
+func TestQueryForPositionalSource(t *testing.T) {
+	q := mustNewQuery(t, "swap", "SELECT $2, $1, $2")
+	args := map[string]interface{}{"arg1": "a", "arg2": "b"}
+
+	if result := q.QueryFor(DialectMySQL); result != "-- name: swap\nSELECT ?, ?, ?" {
+		t.Errorf("QueryFor: got %q", result)
+	}
+	if prepared := q.PrepareFor(DialectMySQL, args); !reflect.DeepEqual(prepared, []interface{}{"b", "a", "b"}) {
+		t.Errorf("PrepareFor: got %v", prepared)
+	}
+	if result := q.QueryFor(DialectSQLServer); result != "-- name: swap\nSELECT @p2, @p1, @p2" {
+		t.Errorf("QueryFor: got %q", result)
+	}
+}
+
+func TestWithDialect(t *testing.T) {
+	path := writeSQLFile(t, t.TempDir(), "users.sql", "-- name: get-user\nSELECT * FROM users WHERE id = :id OR parent_id = :id\n")
+
+	s := NewQueryStore(WithDialect(DialectMySQL))
+	if err := s.LoadFromFile(path); err != nil {
+		t.Fatal(err)
+	}
+
+	q := s.MustHaveQuery("get-user")
+	if q.Query() != "-- name: get-user\nSELECT * FROM users WHERE id = ? OR parent_id = ?" {
+		t.Errorf("Query: got %q", q.Query())
+	}
+	if prepared := q.Prepare(map[string]interface{}{"id": 5}); !reflect.DeepEqual(prepared, []interface{}{5, 5}) {
+		t.Errorf("Prepare: got %v", prepared)
+	}
+}
+
+func TestValidateDialects(t *testing.T) {
+	s := NewQueryStore()
+	s.insert("get-user", mustNewQuery(t, "get-user", "SELECT * FROM users WHERE id = :id AND name = :name OR alias = :name"))
+	s.insert("list-users", mustNewQuery(t, "list-users", "SELECT * FROM users"))
+	s.insert("swap", mustNewQuery(t, "swap", "SELECT $2, $1"))
+
+	if errs := s.ValidateDialects(DialectPostgres, DialectMySQL, DialectSQLServer, DialectOracle); len(errs) != 0 {
+		t.Errorf("ValidateDialects: unexpected errors %v", errs)
+	}
+
+	broken := []Dialect{
+		{Name: "constant", Placeholder: func(int) string { return "$" }},
+		{Name: "empty", Placeholder: func(int) string { return "" }, Positional: true},
+		{Name: "named", Placeholder: func(int) string { return ":name" }, Positional: true},
+		{Name: "missing"},
+	}
+	for _, d := range broken {
+		t.Run(d.Name, func(t *testing.T) {
+			errs := s.ValidateDialects(d)
+			if len(errs) == 0 {
+				t.Fatalf("expected errors for broken dialect")
+			}
+			if !strings.Contains(errs[0].Error(), "get-user") {
+				t.Errorf("error does not name the query: %v", errs[0])
+			}
+		})
+	}
+}
+
+func TestSQLWithQuestionMarks(t *testing.T) {
+	testCases := []struct {
+		name          string
+		query         string
+		opts          options
+		expectedSQL   string
+		expectedArgs  []interface{}
+		expectedError bool
+	}{
+		{
+			name:         "repeated param",
+			query:        "SELECT * FROM users WHERE (owner_id = :user_id OR manager_id = :user_id) AND status = :status",
+			expectedSQL:  "SELECT * FROM users WHERE (owner_id = ? OR manager_id = ?) AND status = ?",
+			expectedArgs: []interface{}{7, 7, "active"},
+		},
+		{
+			name:         "positional",
+			query:        "SELECT * FROM users WHERE status = $2 AND id = $1",
+			expectedSQL:  "SELECT * FROM users WHERE status = ? AND id = ?",
+			expectedArgs: []interface{}{"active", 7},
+		},
+		{
+			name:         "question marks in literals",
+			query:        "SELECT '?' FROM users WHERE id = :user_id -- why?",
+			expectedSQL:  "SELECT '?' FROM users WHERE id = ? -- why?",
+			expectedArgs: []interface{}{7},
+		},
+		{
+			name:          "jsonb operator",
+			query:         "SELECT * FROM users WHERE settings ? 'beta' AND id = :user_id",
+			expectedError: true,
+		},
+	}
+
+	args := map[string]interface{}{"user_id": 7, "status": "active", "arg1": 7, "arg2": "active"}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			q := mustNewQueryWith(t, tc.name, tc.query, tc.opts)
+
+			sql, err := q.SQLWithQuestionMarks()
+			if tc.expectedError {
+				if err == nil {
+					t.Errorf("SQLWithQuestionMarks: expected error, got %q", sql)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("SQLWithQuestionMarks: %v", err)
+			}
+
+			if sql != tc.expectedSQL {
+				t.Errorf("SQLWithQuestionMarks: got %q, expected %q", sql, tc.expectedSQL)
+			}
+			if prepared := q.PrepareFor(DialectMySQL, args); !reflect.DeepEqual(prepared, tc.expectedArgs) {
+				t.Errorf("PrepareFor: got %v, expected %v", prepared, tc.expectedArgs)
+			}
+		})
+	}
+}
@@ -0,0 +1,37 @@
+package queries
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrQueryNotFound is returned, wrapped in a QueryNotFoundError, when no
+// query with the requested name is loaded
+var ErrQueryNotFound = errors.New("query not found")
+
+// QueryNotFoundError reports the name of a query missing from the store.
+// It matches ErrQueryNotFound with errors.Is.
+type QueryNotFoundError struct {
+	Name string
+}
+
+func (e *QueryNotFoundError) Error() string {
+	return fmt.Sprintf("Query '%s' not found", e.Name)
+}
+
+func (e *QueryNotFoundError) Unwrap() error {
+	return ErrQueryNotFound
+}
+
+// MixedParameterStyleError is returned for a query using more than one
+// parameter style, e.g. both :name and $1
+type MixedParameterStyleError struct {
+	Name string
+	// Styles lists the detected styles, sorted
+	Styles []string
+}
+
+func (e *MixedParameterStyleError) Error() string {
+	return fmt.Sprintf("Query '%s' mixes parameter styles: %s", e.Name, strings.Join(e.Styles, ", "))
+}
@@ -0,0 +1,98 @@
+package queries
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestQueryNotFoundError(t *testing.T) {
+	s := NewQueryStore()
+	if err := s.LoadFromString("users.sql", "-- name: get-user\nSELECT 1\n"); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := s.Query("get-users")
+	if !errors.Is(err, ErrQueryNotFound) {
+		t.Fatalf("errors.Is(%v, ErrQueryNotFound): got false", err)
+	}
+
+	var notFound *QueryNotFoundError
+	if !errors.As(err, &notFound) {
+		t.Fatalf("errors.As(%v, *QueryNotFoundError): got false", err)
+	}
+	if notFound.Name != "get-users" {
+		t.Errorf("Name: got %q, expected %q", notFound.Name, "get-users")
+	}
+	if err.Error() != "Query 'get-users' not found" {
+		t.Errorf("Error: got %q", err.Error())
+	}
+
+	if _, err := s.Query("get-user"); err != nil {
+		t.Errorf("Query(get-user): %v", err)
+	}
+}
+
+func TestMixedParameterStyleError(t *testing.T) {
+	dir := t.TempDir()
+	writeSQLFile(t, dir, "users.sql", "-- name: get-user\nSELECT * FROM users WHERE id = :id AND status = $2\n")
+
+	err := NewQueryStore().LoadFromDir(dir)
+
+	var mixed *MixedParameterStyleError
+	if !errors.As(err, &mixed) {
+		t.Fatalf("errors.As(%v, *MixedParameterStyleError): got false", err)
+	}
+	if mixed.Name != "get-user" {
+		t.Errorf("Name: got %q, expected %q", mixed.Name, "get-user")
+	}
+
+	expected := []string{styleNamed, stylePositional}
+	if !reflect.DeepEqual(mixed.Styles, expected) {
+		t.Errorf("Styles: got %v, expected %v", mixed.Styles, expected)
+	}
+
+	path := writeSQLFile(t, dir, "orders.sql", `-- name: list-orders
+SELECT * FROM orders
+
+-- name: get-order
+-- description: mixes styles
+SELECT * FROM orders
+WHERE id = :id AND status = $1
+
+-- name: count-orders
+SELECT count(*) FROM orders
+`)
+	err = NewQueryStore().LoadFromFile(path)
+
+	var buried *MixedParameterStyleError
+	if !errors.As(err, &buried) || buried.Name != "get-order" {
+		t.Fatalf("LoadFromFile: got %v, expected mixed style error for get-order", err)
+	}
+	if prefix := path + ":4: "; !strings.HasPrefix(err.Error(), prefix) {
+		t.Errorf("LoadFromFile: %q does not start with %q", err.Error(), prefix)
+	}
+
+	message := "Query 'get-user' mixes parameter styles: named (:name), positional ($1)"
+	if mixed.Error() != message {
+		t.Errorf("Error: got %q, expected %q", mixed.Error(), message)
+	}
+}
+
+func TestParseQueryMixedStyles(t *testing.T) {
+	_, err := ParseQuery("get-user", "SELECT * FROM users WHERE id = :id AND status = $2")
+
+	var mixed *MixedParameterStyleError
+	if !errors.As(err, &mixed) || mixed.Name != "get-user" {
+		t.Fatalf("ParseQuery: got %v, expected *MixedParameterStyleError", err)
+	}
+
+	q := NewQuery("get-user", "SELECT * FROM users WHERE id = :id AND status = $2")
+	if !errors.As(q.Err(), &mixed) {
+		t.Errorf("NewQuery: got error %v, expected *MixedParameterStyleError", q.Err())
+	}
+	if _, err := q.PrepareArgs(1, 2); !errors.As(err, &mixed) {
+		t.Errorf("PrepareArgs: got %v, expected *MixedParameterStyleError", err)
+	}
+}
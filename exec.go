@@ -0,0 +1,195 @@
+package queries
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+	"time"
+)
+
+var (
+	returningRE = regexp.MustCompile(`(?i)\bRETURNING\b`)
+)
+
+// PrepareServerSide issues "PREPARE <PreparedName> AS <query>" on the
+// connection, so the statement can be run with EXECUTE for the lifetime of
+// the session
+func (q *Query) PrepareServerSide(ctx context.Context, conn *sql.Conn) error {
+	_, err := conn.ExecContext(ctx, fmt.Sprintf("PREPARE %s AS %s", q.PreparedName(), q.SQL()))
+	if err != nil {
+		return fmt.Errorf("Error preparing query '%s': %v", q.Name, err)
+	}
+
+	return nil
+}
+
+// Exec runs the query with the prepared args, see Prepare
+func (q *Query) Exec(ctx context.Context, db *sql.DB, args map[string]interface{}) (sql.Result, error) {
+	return db.ExecContext(ctx, q.SQL(), q.Prepare(args)...)
+}
+
+// ExecWithTimeout is Exec bounded by the "timeout" metadata of the query,
+// e.g. "timeout: 50ms". Without it ctx is used unchanged.
+func (q *Query) ExecWithTimeout(ctx context.Context, db *sql.DB, args map[string]interface{}) (sql.Result, error) {
+	timeout, ok, err := q.GetMetadataDuration("timeout")
+	if err != nil {
+		return nil, err
+	}
+
+	if ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	return q.Exec(ctx, db, args)
+}
+
+// QueryContext runs the query with the prepared args and returns the rows
+func (q *Query) QueryContext(ctx context.Context, db *sql.DB, args map[string]interface{}) (*sql.Rows, error) {
+	return db.QueryContext(ctx, q.SQL(), q.Prepare(args)...)
+}
+
+// QueryRow runs the query with the prepared args and returns at most one
+// row. Missing args are bound as NULL.
+func (q *Query) QueryRow(ctx context.Context, db *sql.DB, args map[string]interface{}) *sql.Row {
+	return db.QueryRowContext(ctx, q.SQL(), q.Prepare(args)...)
+}
+
+// QueryOne runs the query and scans the first row into dest. The number of
+// rows is checked against the query's Expect cardinality; a query without
+// rows that is not expected to return one reports sql.ErrNoRows.
+func (q *Query) QueryOne(ctx context.Context, db *sql.DB, args map[string]interface{}, dest ...interface{}) error {
+	rows, err := db.QueryContext(ctx, q.SQL(), q.Prepare(args)...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	count := 0
+	if rows.Next() {
+		count++
+		if err := rows.Scan(dest...); err != nil {
+			return err
+		}
+		if rows.Next() {
+			count++
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	if err := q.Expect.check(count); err != nil {
+		return fmt.Errorf("Query '%s' %w", q.Name, err)
+	}
+	if count == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+// ExecReturning runs an INSERT/UPDATE/DELETE ... RETURNING query and scans
+// the returned rows into T. The length of the result is the number of
+// affected rows. T is either a struct, whose fields are matched to columns
+// by their `db` tag or case-insensitively by name ignoring underscores, or
+// a single-column scalar type.
+func ExecReturning[T any](ctx context.Context, db *sql.DB, q *Query, args map[string]interface{}) ([]T, error) {
+	if !hasReturning(q) {
+		return nil, fmt.Errorf("Query '%s' has no RETURNING clause", q.Name)
+	}
+
+	rows, err := db.QueryContext(ctx, q.SQL(), q.Prepare(args)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanAll[T](rows)
+}
+
+// hasReturning reports whether the query has a RETURNING clause outside
+// comments and literals
+func hasReturning(q *Query) bool {
+	return returningRE.MatchString(maskLiterals(stripSQLComments(q.Raw)))
+}
+
+// scanAll scans every row into a T
+func scanAll[T any](rows *sql.Rows) ([]T, error) {
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+
+	var zero T
+	typ := reflect.TypeOf(zero)
+
+	var fields [][]int
+	if typ != nil && typ.Kind() == reflect.Struct && !typ.ConvertibleTo(reflect.TypeOf(time.Time{})) {
+		if fields, err = columnFields(typ, columns); err != nil {
+			return nil, err
+		}
+	} else if len(columns) != 1 {
+		return nil, fmt.Errorf("Cannot scan %d columns into %T", len(columns), zero)
+	}
+
+	results := []T{}
+	for rows.Next() {
+		var item T
+		dest := []interface{}{&item}
+
+		if fields != nil {
+			value := reflect.ValueOf(&item).Elem()
+			dest = make([]interface{}, len(fields))
+			for i, index := range fields {
+				dest[i] = value.FieldByIndex(index).Addr().Interface()
+			}
+		}
+
+		if err := rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+		results = append(results, item)
+	}
+
+	return results, rows.Err()
+}
+
+// columnFields maps every column to a field of the struct type
+func columnFields(typ reflect.Type, columns []string) ([][]int, error) {
+	byName := make(map[string][]int)
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+
+		name := field.Tag.Get("db")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		byName[normalizeColumn(name)] = field.Index
+	}
+
+	fields := make([][]int, len(columns))
+	for i, column := range columns {
+		index, ok := byName[normalizeColumn(column)]
+		if !ok {
+			return nil, fmt.Errorf("No field in %s for column '%s'", typ, column)
+		}
+		fields[i] = index
+	}
+
+	return fields, nil
+}
+
+func normalizeColumn(name string) string {
+	return strings.ToLower(strings.ReplaceAll(name, "_", ""))
+}
@@ -0,0 +1,325 @@
+package queries
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func TestPreparedName(t *testing.T) {
+	testCases := []struct {
+		name     string
+		expected string
+	}{
+		{name: "get-user", expected: `^q_get_user_[0-9a-f]{8}$`},
+		{name: "users/List Active", expected: `^q_users_list_active_[0-9a-f]{8}$`},
+		{name: "get_user", expected: `^q_get_user_[0-9a-f]{8}$`},
+	}
+
+	seen := map[string]string{}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			name := mustNewQuery(t, tc.name, "SELECT 1").PreparedName()
+			if !regexp.MustCompile(tc.expected).MatchString(name) {
+				t.Errorf("PreparedName: got %q, expected to match %s", name, tc.expected)
+			}
+			if other, ok := seen[name]; ok {
+				t.Errorf("PreparedName collision between %q and %q", other, tc.name)
+			}
+			seen[name] = tc.name
+
+			if again := mustNewQuery(t, tc.name, "SELECT 2").PreparedName(); again != name {
+				t.Errorf("PreparedName is not stable: %q vs %q", name, again)
+			}
+		})
+	}
+
+	long := mustNewQuery(t, "a-very-long-query-name-that-goes-well-beyond-the-postgres-identifier-limit", "SELECT 1")
+	if name := long.PreparedName(); len(name) > 63 {
+		t.Errorf("PreparedName exceeds 63 bytes: %q", name)
+	}
+}
+
+func TestPrepareServerSide(t *testing.T) {
+	db, fdb := openFakeDB(t, nil)
+
+	conn, err := db.Conn(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	q := mustNewQuery(t, "get-user", "SELECT * FROM users WHERE id = :id")
+	if err := q.PrepareServerSide(context.Background(), conn); err != nil {
+		t.Fatalf("PrepareServerSide: %v", err)
+	}
+
+	calls := fdb.calls()
+	expected := "PREPARE " + q.PreparedName() + " AS SELECT * FROM users WHERE id = $1"
+	if len(calls) != 1 || calls[0].Query != expected {
+		t.Errorf("executed %v, expected %q", calls, expected)
+	}
+}
+
+func TestQueryOneExpect(t *testing.T) {
+	rows := func(n int) fakeHandler {
+		return func(context.Context, string, []interface{}) (*fakeResult, error) {
+			res := &fakeResult{Columns: []string{"id"}}
+			for i := 0; i < n; i++ {
+				res.Rows = append(res.Rows, []driver.Value{int64(i + 1)})
+			}
+			return res, nil
+		}
+	}
+
+	testCases := []struct {
+		expect   Cardinality
+		rows     int
+		expected error
+	}{
+		{expect: ExpectAny, rows: 0, expected: sql.ErrNoRows},
+		{expect: ExpectAny, rows: 1, expected: nil},
+		{expect: ExpectAny, rows: 3, expected: nil},
+		{expect: ExpectExactlyOne, rows: 0, expected: ErrRowCount},
+		{expect: ExpectExactlyOne, rows: 1, expected: nil},
+		{expect: ExpectExactlyOne, rows: 3, expected: ErrRowCount},
+		{expect: ExpectAtMostOne, rows: 0, expected: sql.ErrNoRows},
+		{expect: ExpectAtMostOne, rows: 1, expected: nil},
+		{expect: ExpectAtMostOne, rows: 3, expected: ErrRowCount},
+		{expect: ExpectAtLeastOne, rows: 0, expected: ErrRowCount},
+		{expect: ExpectAtLeastOne, rows: 1, expected: nil},
+		{expect: ExpectAtLeastOne, rows: 3, expected: nil},
+	}
+
+	for _, tc := range testCases {
+		t.Run(fmt.Sprintf("%s/%d", tc.expect, tc.rows), func(t *testing.T) {
+			db, fdb := openFakeDB(t, rows(tc.rows))
+
+			q := mustNewQuery(t, "get-user", "SELECT id FROM users WHERE email = :email")
+			q.Expect = tc.expect
+
+			var id int64
+			err := q.QueryOne(context.Background(), db, map[string]interface{}{"email": "a@example.com"}, &id)
+			if !errors.Is(err, tc.expected) || (tc.expected == nil && err != nil) {
+				t.Fatalf("QueryOne: got %v, expected %v", err, tc.expected)
+			}
+			if err == nil && id != 1 {
+				t.Errorf("scanned id: got %d, expected 1", id)
+			}
+
+			calls := fdb.calls()
+			if len(calls) != 1 || calls[0].Query != "SELECT id FROM users WHERE email = $1" {
+				t.Errorf("executed %v", calls)
+			}
+		})
+	}
+}
+
+func TestExpectMetadata(t *testing.T) {
+	dir := t.TempDir()
+	path := writeSQLFile(t, dir, "users.sql", "-- name: get-user\n-- expect: Exactly-One\nSELECT * FROM users WHERE id = :id\n\n-- name: list-users\nSELECT * FROM users\n")
+
+	s := NewQueryStore()
+	if err := s.LoadFromFile(path); err != nil {
+		t.Fatal(err)
+	}
+	if expect := s.MustHaveQuery("get-user").Expect; expect != ExpectExactlyOne {
+		t.Errorf("get-user: got %s, expected exactly-one", expect)
+	}
+	if expect := s.MustHaveQuery("list-users").Expect; expect != ExpectAny {
+		t.Errorf("list-users: got %s, expected any", expect)
+	}
+
+	invalid := writeSQLFile(t, dir, "invalid.sql", "-- name: broken\n-- expect: a-few\nSELECT 1\n")
+	if err := NewQueryStore().LoadFromFile(invalid); err == nil {
+		t.Errorf("expected error for unknown expect value")
+	}
+}
+
+func TestExecReturning(t *testing.T) {
+	db, fdb := openFakeDB(t, func(context.Context, string, []interface{}) (*fakeResult, error) {
+		return &fakeResult{
+			Columns: []string{"user_id", "email"},
+			Rows: [][]driver.Value{
+				{int64(1), "a@example.com"},
+				{int64(2), "b@example.com"},
+			},
+		}, nil
+	})
+
+	type user struct {
+		ID    int64 `db:"user_id"`
+		Email string
+	}
+
+	q := mustNewQuery(t, "deactivate", "UPDATE users SET active = false WHERE org_id = :org_id RETURNING user_id, email")
+	users, err := ExecReturning[user](context.Background(), db, q, map[string]interface{}{"org_id": 9})
+	if err != nil {
+		t.Fatalf("ExecReturning: %v", err)
+	}
+
+	expected := []user{{1, "a@example.com"}, {2, "b@example.com"}}
+	if !reflect.DeepEqual(users, expected) {
+		t.Errorf("ExecReturning: got %v, expected %v", users, expected)
+	}
+
+	calls := fdb.calls()
+	if len(calls) != 1 || calls[0].Query != "UPDATE users SET active = false WHERE org_id = $1 RETURNING user_id, email" || !reflect.DeepEqual(calls[0].Args, []interface{}{9}) {
+		t.Errorf("executed %v", calls)
+	}
+
+	if _, err := ExecReturning[int64](context.Background(), db, q, nil); err == nil {
+		t.Errorf("expected error scanning two columns into a scalar")
+	}
+
+	type partial struct {
+		ID int64 `db:"user_id"`
+	}
+	if _, err := ExecReturning[partial](context.Background(), db, q, nil); err == nil {
+		t.Errorf("expected error for column without field")
+	}
+}
+
+func TestExecReturningScalar(t *testing.T) {
+	db, _ := openFakeDB(t, func(context.Context, string, []interface{}) (*fakeResult, error) {
+		return &fakeResult{Columns: []string{"id"}, Rows: [][]driver.Value{{int64(4)}, {int64(5)}}}, nil
+	})
+
+	q := mustNewQuery(t, "purge", "DELETE FROM sessions WHERE expires_at < now() RETURNING id")
+	ids, err := ExecReturning[int64](context.Background(), db, q, nil)
+	if err != nil {
+		t.Fatalf("ExecReturning: %v", err)
+	}
+	if !reflect.DeepEqual(ids, []int64{4, 5}) {
+		t.Errorf("ExecReturning: got %v", ids)
+	}
+
+	noReturning := mustNewQuery(t, "purge", "DELETE FROM sessions -- RETURNING id")
+	if _, err := ExecReturning[int64](context.Background(), db, noReturning, nil); err == nil {
+		t.Errorf("expected error for query without RETURNING")
+	}
+}
+
+func TestExecAndQueryContext(t *testing.T) {
+	db, fdb := openFakeDB(t, func(_ context.Context, query string, args []interface{}) (*fakeResult, error) {
+		return &fakeResult{
+			Columns:  []string{"id"},
+			Rows:     [][]driver.Value{{int64(1)}, {int64(2)}},
+			Affected: 3,
+		}, nil
+	})
+	ctx := context.Background()
+
+	update := mustNewQuery(t, "archive-users", "UPDATE users SET archived = true WHERE org_id = :org_id")
+	res, err := update.Exec(ctx, db, map[string]interface{}{"org_id": 9})
+	if err != nil {
+		t.Fatalf("Exec: %v", err)
+	}
+	if affected, _ := res.RowsAffected(); affected != 3 {
+		t.Errorf("RowsAffected: got %d, expected 3", affected)
+	}
+
+	list := mustNewQuery(t, "list-users", "SELECT id FROM users WHERE org_id = :org_id")
+	rows, err := list.QueryContext(ctx, db, map[string]interface{}{"org_id": 9})
+	if err != nil {
+		t.Fatalf("QueryContext: %v", err)
+	}
+	defer rows.Close()
+
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+	}
+	if !reflect.DeepEqual(ids, []int64{1, 2}) {
+		t.Errorf("QueryContext: got %v", ids)
+	}
+
+	expected := []fakeCall{
+		{Query: "UPDATE users SET archived = true WHERE org_id = $1", Args: []interface{}{9}},
+		{Query: "SELECT id FROM users WHERE org_id = $1", Args: []interface{}{9}},
+	}
+	if calls := fdb.calls(); !reflect.DeepEqual(calls, expected) {
+		t.Errorf("executed %v, expected %v", calls, expected)
+	}
+}
+
+func TestQueryRow(t *testing.T) {
+	db, fdb := openFakeDB(t, func(context.Context, string, []interface{}) (*fakeResult, error) {
+		return &fakeResult{Columns: []string{"email"}, Rows: [][]driver.Value{{"a@example.com"}}}, nil
+	})
+	ctx := context.Background()
+
+	q := mustNewQuery(t, "get-email", "SELECT email FROM users WHERE id = :id AND org_id = :org_id")
+
+	var email string
+	if err := q.QueryRow(ctx, db, map[string]interface{}{"id": 1}).Scan(&email); err != nil {
+		t.Fatalf("QueryRow: %v", err)
+	}
+	if email != "a@example.com" {
+		t.Errorf("QueryRow: got %q", email)
+	}
+
+	// the missing org_id is bound as NULL
+	expected := []fakeCall{
+		{Query: "SELECT email FROM users WHERE id = $1 AND org_id = $2", Args: []interface{}{1, nil}},
+	}
+	if calls := fdb.calls(); !reflect.DeepEqual(calls, expected) {
+		t.Errorf("executed %v, expected %v", calls, expected)
+	}
+}
+
+func TestExecWithTimeout(t *testing.T) {
+	// emulates pg_sleep: the statement only finishes when cancelled or
+	// after a second
+	db, _ := openFakeDB(t, func(ctx context.Context, _ string, _ []interface{}) (*fakeResult, error) {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(time.Second):
+			return &fakeResult{Affected: 1}, nil
+		}
+	})
+
+	s := NewQueryStore()
+	err := s.LoadFromString("sleep.sql", `-- name: sleep-short
+-- timeout: 20ms
+SELECT pg_sleep(1)
+
+-- name: sleep-untimed
+SELECT pg_sleep(1)
+
+-- name: sleep-invalid
+-- timeout: soon
+SELECT pg_sleep(1)
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	_, err = s.MustHaveQuery("sleep-short").ExecWithTimeout(context.Background(), db, nil)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("ExecWithTimeout: got %v, expected context.DeadlineExceeded", err)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("ExecWithTimeout: took %v, timeout did not fire", elapsed)
+	}
+
+	if _, err := s.MustHaveQuery("sleep-untimed").ExecWithTimeout(context.Background(), db, nil); err != nil {
+		t.Errorf("ExecWithTimeout without timeout: %v", err)
+	}
+
+	if _, err := s.MustHaveQuery("sleep-invalid").ExecWithTimeout(context.Background(), db, nil); err == nil {
+		t.Errorf("ExecWithTimeout: expected error for invalid timeout")
+	}
+}
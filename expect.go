@@ -0,0 +1,61 @@
+package queries
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Cardinality is the number of rows a query is expected to return, set by
+// the "-- expect:" metadata
+type Cardinality int
+
+const (
+	ExpectAny Cardinality = iota
+	ExpectExactlyOne
+	ExpectAtMostOne
+	ExpectAtLeastOne
+)
+
+// ErrRowCount is returned when a query returns a number of rows that does
+// not satisfy its expected cardinality
+var ErrRowCount = errors.New("unexpected number of rows")
+
+var cardinalityNames = map[Cardinality]string{
+	ExpectAny:        "any",
+	ExpectExactlyOne: "exactly-one",
+	ExpectAtMostOne:  "at-most-one",
+	ExpectAtLeastOne: "at-least-one",
+}
+
+func (c Cardinality) String() string {
+	if name, ok := cardinalityNames[c]; ok {
+		return name
+	}
+
+	return fmt.Sprintf("Cardinality(%d)", int(c))
+}
+
+func parseCardinality(value string) (Cardinality, error) {
+	value = strings.ToLower(strings.TrimSpace(value))
+	for c, name := range cardinalityNames {
+		if name == value {
+			return c, nil
+		}
+	}
+
+	return ExpectAny, fmt.Errorf("Unknown expect value '%s'", value)
+}
+
+// check verifies the number of rows seen against the expectation.
+// rows is only exact up to 2, which is enough for every cardinality.
+func (c Cardinality) check(rows int) error {
+	switch {
+	case rows == 0 && (c == ExpectExactlyOne || c == ExpectAtLeastOne):
+		return fmt.Errorf("expected %s row, got none: %w", c, ErrRowCount)
+	case rows > 1 && (c == ExpectExactlyOne || c == ExpectAtMostOne):
+		return fmt.Errorf("expected %s row, got more: %w", c, ErrRowCount)
+	}
+
+	return nil
+}
@@ -0,0 +1,76 @@
+package queries
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// WriteSQL writes all loaded queries sorted by name as a single .sql file
+// that loads back into the same queries. Every query gets its name and
+// alias directives, its metadata as authored, its parameter annotations and
+// its raw body.
+func (s *QueryStore) WriteSQL(w io.Writer) error {
+	s.mu.RLock()
+	queries := s.queriesSorted()
+	s.mu.RUnlock()
+
+	bw := bufio.NewWriter(w)
+	for i, q := range queries {
+		if i > 0 {
+			bw.WriteString("\n")
+		}
+		writeQuerySQL(bw, q)
+	}
+
+	return bw.Flush()
+}
+
+func writeQuerySQL(w *bufio.Writer, q *Query) {
+	fmt.Fprintf(w, "-- name: %s\n", directiveValue(q.Name))
+	for _, alias := range q.Aliases {
+		fmt.Fprintf(w, "-- alias: %s\n", directiveValue(alias))
+	}
+
+	metadata := q.metadataOriginal
+	if metadata == nil {
+		metadata = q.Metadata
+	}
+	keys := make([]string, 0, len(metadata))
+	for key := range metadata {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		fmt.Fprintf(w, "-- %s: %s\n", key, metadata[key])
+	}
+
+	params := make([]string, 0, len(q.ParamTypes))
+	for param := range q.ParamTypes {
+		params = append(params, param)
+	}
+	sort.Strings(params)
+	for _, param := range params {
+		fmt.Fprintf(w, "-- param: %s %s\n", param, q.ParamTypes[param])
+	}
+
+	// queries including others keep their directives, not the inlined body
+	body := q.Raw
+	if q.source != "" {
+		body = q.source
+	}
+	w.WriteString(body)
+	w.WriteString("\n")
+}
+
+// directiveValue quotes names the scanner would otherwise cut at the first
+// space
+func directiveValue(name string) string {
+	if strings.IndexFunc(name, unicode.IsSpace) >= 0 {
+		return `"` + name + `"`
+	}
+	return name
+}
@@ -0,0 +1,108 @@
+package queries
+
+import (
+	"bytes"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestWriteSQL(t *testing.T) {
+	content := "-- name: list-users\n-- Description: All users\n-- timeout: 50ms\nSELECT *\nFROM users\nLIMIT :n::int\n\n" +
+		"-- name: \"get user\"\n-- alias: get-user\n-- expect: exactly-one\nSELECT * FROM users WHERE id = :id\n\n" +
+		"-- name: delete-user\nDELETE FROM users WHERE id = $1\n"
+
+	s := NewQueryStore()
+	if err := s.LoadFromString("users.sql", content); err != nil {
+		t.Fatalf("LoadFromString: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err := s.WriteSQL(&buf); err != nil {
+		t.Fatalf("WriteSQL: %v", err)
+	}
+
+	if !strings.HasPrefix(buf.String(), "-- name: delete-user\n") {
+		t.Errorf("queries not sorted by name:\n%s", buf.String())
+	}
+
+	reloaded := NewQueryStore()
+	if err := reloaded.LoadFromString("users.sql", buf.String()); err != nil {
+		t.Fatalf("reloading written SQL: %v\n%s", err, buf.String())
+	}
+
+	if reloaded.Len() != s.Len() {
+		t.Fatalf("got %d queries after reload, expected %d", reloaded.Len(), s.Len())
+	}
+	if warnings := reloaded.Warnings(); len(warnings) != len(s.Warnings()) {
+		t.Errorf("reload warnings: %v", warnings)
+	}
+
+	for name, q := range s.Queries() {
+		r, err := reloaded.Query(name)
+		if err != nil {
+			t.Errorf("Query %s: %v", name, err)
+			continue
+		}
+
+		if r.Raw != q.Raw {
+			t.Errorf("%s: got body %q, expected %q", name, r.Raw, q.Raw)
+		}
+		if r.Style != q.Style {
+			t.Errorf("%s: got style %v, expected %v", name, r.Style, q.Style)
+		}
+		if !reflect.DeepEqual(r.Aliases, q.Aliases) {
+			t.Errorf("%s: got aliases %v, expected %v", name, r.Aliases, q.Aliases)
+		}
+		if !reflect.DeepEqual(r.MetadataOriginal(), q.MetadataOriginal()) {
+			t.Errorf("%s: got metadata %v, expected %v", name, r.MetadataOriginal(), q.MetadataOriginal())
+		}
+	}
+}
+
+func TestWriteSQLIncludes(t *testing.T) {
+	content := "-- name: active\nactive AND NOT deleted\n\n" +
+		"-- name: list-users\nSELECT * FROM users WHERE\n-- include: active\n"
+
+	s := NewQueryStore()
+	if err := s.LoadFromString("users.sql", content); err != nil {
+		t.Fatalf("LoadFromString: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err := s.WriteSQL(&buf); err != nil {
+		t.Fatalf("WriteSQL: %v", err)
+	}
+	if !strings.Contains(buf.String(), "-- include: active\n") {
+		t.Errorf("include directive not written:\n%s", buf.String())
+	}
+
+	reloaded := NewQueryStore()
+	if err := reloaded.LoadFromString("users.sql", buf.String()); err != nil {
+		t.Fatalf("reloading written SQL: %v\n%s", err, buf.String())
+	}
+
+	q, err := s.Query("list-users")
+	if err != nil {
+		t.Fatalf("Query: %v", err)
+	}
+	r, err := reloaded.Query("list-users")
+	if err != nil {
+		t.Fatalf("Query after reload: %v", err)
+	}
+	if r.Raw != q.Raw {
+		t.Errorf("got body %q, expected %q", r.Raw, q.Raw)
+	}
+	if r.source != q.source {
+		t.Errorf("got source %q, expected %q", r.source, q.source)
+	}
+}
+
+func TestWriteSQLWhileWriting(t *testing.T) {
+	readWhileWriting(t, func(s *QueryStore) {
+		if err := s.WriteSQL(io.Discard); err != nil {
+			t.Errorf("WriteSQL: %v", err)
+		}
+	})
+}
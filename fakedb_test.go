@@ -0,0 +1,193 @@
+package queries
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// fakeDB is an in-memory database/sql driver used to test the execution
+// helpers. Every statement is passed to handler, which decides the result.
+type fakeDB struct {
+	mu       sync.Mutex
+	handler  fakeHandler
+	executed []fakeCall
+	// prepare, if set, is called for every prepared statement
+	prepare func(query string)
+}
+
+type fakeCall struct {
+	Query string
+	Args  []interface{}
+}
+
+type fakeHandler func(ctx context.Context, query string, args []interface{}) (*fakeResult, error)
+
+type fakeResult struct {
+	Columns  []string
+	Rows     [][]driver.Value
+	Affected int64
+}
+
+var (
+	fakeDBs   sync.Map
+	fakeDBSeq int64
+)
+
+func init() {
+	sql.Register("queries-fake", fakeDriver{})
+}
+
+// openFakeDB opens a *sql.DB backed by handler. A nil handler returns empty
+// results for every statement.
+func openFakeDB(t *testing.T, handler fakeHandler) (*sql.DB, *fakeDB) {
+	t.Helper()
+
+	if handler == nil {
+		handler = func(context.Context, string, []interface{}) (*fakeResult, error) {
+			return &fakeResult{}, nil
+		}
+	}
+
+	fdb := &fakeDB{handler: handler}
+	dsn := fmt.Sprintf("fake-%d", atomic.AddInt64(&fakeDBSeq, 1))
+	fakeDBs.Store(dsn, fdb)
+
+	db, err := sql.Open("queries-fake", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		db.Close()
+		fakeDBs.Delete(dsn)
+	})
+
+	return db, fdb
+}
+
+func (f *fakeDB) calls() []fakeCall {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return append([]fakeCall(nil), f.executed...)
+}
+
+func (f *fakeDB) run(ctx context.Context, query string, named []driver.NamedValue) (*fakeResult, error) {
+	args := make([]interface{}, len(named))
+	for i, nv := range named {
+		args[i] = nv.Value
+	}
+
+	f.mu.Lock()
+	f.executed = append(f.executed, fakeCall{Query: query, Args: args})
+	f.mu.Unlock()
+
+	return f.handler(ctx, query, args)
+}
+
+type fakeDriver struct{}
+
+func (fakeDriver) Open(dsn string) (driver.Conn, error) {
+	fdb, ok := fakeDBs.Load(dsn)
+	if !ok {
+		return nil, fmt.Errorf("unknown fake database %q", dsn)
+	}
+
+	return &fakeConn{db: fdb.(*fakeDB)}, nil
+}
+
+type fakeConn struct {
+	db *fakeDB
+}
+
+func (c *fakeConn) Prepare(query string) (driver.Stmt, error) {
+	if c.db.prepare != nil {
+		c.db.prepare(query)
+	}
+	return &fakeStmt{conn: c, query: query}, nil
+}
+
+func (c *fakeConn) Close() error { return nil }
+
+func (c *fakeConn) Begin() (driver.Tx, error) {
+	return nil, errors.New("transactions are not supported")
+}
+
+func (c *fakeConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	res, err := c.db.run(ctx, query, args)
+	if err != nil {
+		return nil, err
+	}
+
+	return driver.RowsAffected(res.Affected), nil
+}
+
+func (c *fakeConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	res, err := c.db.run(ctx, query, args)
+	if err != nil {
+		return nil, err
+	}
+
+	return &fakeRows{result: res}, nil
+}
+
+// CheckNamedValue accepts any argument so tests can pass slices and maps
+func (c *fakeConn) CheckNamedValue(*driver.NamedValue) error { return nil }
+
+type fakeStmt struct {
+	conn  *fakeConn
+	query string
+}
+
+func (s *fakeStmt) Close() error  { return nil }
+func (s *fakeStmt) NumInput() int { return -1 }
+
+func (s *fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+	return s.ExecContext(context.Background(), toNamed(args))
+}
+
+func (s *fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	return s.QueryContext(context.Background(), toNamed(args))
+}
+
+func (s *fakeStmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	return s.conn.ExecContext(ctx, s.query, args)
+}
+
+func (s *fakeStmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	return s.conn.QueryContext(ctx, s.query, args)
+}
+
+func toNamed(args []driver.Value) []driver.NamedValue {
+	named := make([]driver.NamedValue, len(args))
+	for i, v := range args {
+		named[i] = driver.NamedValue{Ordinal: i + 1, Value: v}
+	}
+
+	return named
+}
+
+type fakeRows struct {
+	result *fakeResult
+	pos    int
+}
+
+func (r *fakeRows) Columns() []string { return r.result.Columns }
+func (r *fakeRows) Close() error      { return nil }
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if r.pos >= len(r.result.Rows) {
+		return io.EOF
+	}
+
+	copy(dest, r.result.Rows[r.pos])
+	r.pos++
+
+	return nil
+}
@@ -0,0 +1,101 @@
+package queries
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"go/token"
+	"io"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// GenerateGo writes the source of a Go file in package pkg with a constant
+// and an accessor function per loaded query, e.g. QueryGetUser and
+// GetUser() for "get-user". The accessors read from the package level
+// Store variable of the generated file, which has to be set once the
+// queries are loaded. A query missing at runtime still panics, but a query
+// removed from the SQL files turns into a compile error after regenerating.
+func (s *QueryStore) GenerateGo(pkg string, w io.Writer) error {
+	if !token.IsIdentifier(pkg) {
+		return fmt.Errorf("invalid package name '%s'", pkg)
+	}
+
+	names := s.QueryNames()
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "// Code generated by queries.GenerateGo. DO NOT EDIT.\n\n")
+	fmt.Fprintf(&buf, "package %s\n\n", pkg)
+	fmt.Fprintf(&buf, "import \"github.com/boringsql/queries\"\n\n")
+	fmt.Fprintf(&buf, "// Store holds the queries returned by the accessors of this file\n")
+	fmt.Fprintf(&buf, "var Store *queries.QueryStore\n\n")
+
+	idents := goIdentifiers(names)
+
+	if len(names) > 0 {
+		fmt.Fprintf(&buf, "// Query names\nconst (\n")
+		for _, name := range names {
+			fmt.Fprintf(&buf, "Query%s = %s\n", idents[name], strconv.Quote(name))
+		}
+		fmt.Fprintf(&buf, ")\n")
+	}
+
+	for _, name := range names {
+		ident := idents[name]
+		fmt.Fprintf(&buf, "\n// %s returns the %s query\n", ident, strconv.Quote(name))
+		fmt.Fprintf(&buf, "func %s() *queries.Query {\nreturn Store.MustHaveQuery(Query%s)\n}\n", ident, ident)
+	}
+
+	src, err := format.Source(buf.Bytes())
+	if err != nil {
+		return fmt.Errorf("formatting generated code: %w", err)
+	}
+
+	_, err = w.Write(src)
+	return err
+}
+
+// goIdentifiers maps every query name to an exported Go identifier. Names
+// that sanitize to the same identifier get a numeric suffix in name order.
+func goIdentifiers(names []string) map[string]string {
+	// the accessor and its Query constant must both be free
+	used := map[string]bool{"Store": true}
+	idents := make(map[string]string, len(names))
+
+	for _, name := range names {
+		base := goIdentifier(name)
+		ident := base
+		for n := 2; used[ident] || used["Query"+ident]; n++ {
+			ident = base + strconv.Itoa(n)
+		}
+
+		used[ident], used["Query"+ident] = true, true
+		idents[name] = ident
+	}
+
+	return idents
+}
+
+// goIdentifier converts a query name to CamelCase, treating every
+// character that can't appear in an identifier as a word separator
+func goIdentifier(name string) string {
+	words := strings.FieldsFunc(name, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+
+	var b strings.Builder
+	for _, word := range words {
+		runes := []rune(word)
+		b.WriteRune(unicode.ToUpper(runes[0]))
+		b.WriteString(string(runes[1:]))
+	}
+
+	ident := b.String()
+	if ident == "" || !unicode.IsUpper([]rune(ident)[0]) {
+		// digits or letters without case can't start an exported name
+		ident = "Q" + ident
+	}
+
+	return ident
+}
@@ -0,0 +1,76 @@
+package queries
+
+import (
+	"bytes"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"reflect"
+	"regexp"
+	"sort"
+	"testing"
+)
+
+func TestGenerateGo(t *testing.T) {
+	s := NewQueryStore()
+	content := "-- name: get-user\nSELECT * FROM users WHERE id = :id\n\n" +
+		"-- name: get_user\nSELECT * FROM users WHERE id = :id\n\n" +
+		"-- name: \"list all users\"\nSELECT * FROM users\n\n" +
+		"-- name: 2fa-codes\nSELECT * FROM codes\n\n" +
+		"-- name: query-get-user\nSELECT 1\n"
+	if err := s.LoadFromString("users.sql", content); err != nil {
+		t.Fatalf("LoadFromString: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err := s.GenerateGo("db", &buf); err != nil {
+		t.Fatalf("GenerateGo: %v", err)
+	}
+
+	file, err := parser.ParseFile(token.NewFileSet(), "queries.go", buf.Bytes(), 0)
+	if err != nil {
+		t.Fatalf("generated code doesn't parse: %v\n%s", err, buf.String())
+	}
+
+	if file.Name.Name != "db" {
+		t.Errorf("package: got %s, expected db", file.Name.Name)
+	}
+
+	var funcs, consts []string
+	for _, decl := range file.Decls {
+		switch d := decl.(type) {
+		case *ast.FuncDecl:
+			funcs = append(funcs, d.Name.Name)
+		case *ast.GenDecl:
+			if d.Tok != token.CONST {
+				continue
+			}
+			for _, spec := range d.Specs {
+				consts = append(consts, spec.(*ast.ValueSpec).Names[0].Name)
+			}
+		}
+	}
+	sort.Strings(funcs)
+	sort.Strings(consts)
+
+	expectedFuncs := []string{"GetUser", "GetUser2", "ListAllUsers", "Q2faCodes", "QueryGetUser3"}
+	if !reflect.DeepEqual(funcs, expectedFuncs) {
+		t.Errorf("functions: got %v, expected %v", funcs, expectedFuncs)
+	}
+
+	expectedConsts := []string{"QueryGetUser", "QueryGetUser2", "QueryListAllUsers", "QueryQ2faCodes", "QueryQueryGetUser3"}
+	if !reflect.DeepEqual(consts, expectedConsts) {
+		t.Errorf("constants: got %v, expected %v", consts, expectedConsts)
+	}
+
+	if !regexp.MustCompile(`QueryListAllUsers\s+= "list all users"`).Match(buf.Bytes()) {
+		t.Errorf("missing constant for quoted name:\n%s", buf.String())
+	}
+}
+
+func TestGenerateGoInvalidPackage(t *testing.T) {
+	var buf bytes.Buffer
+	if err := NewQueryStore().GenerateGo("my-db", &buf); err == nil {
+		t.Errorf("expected error for invalid package name")
+	}
+}
@@ -1,3 +1,7 @@
 module github.com/boringsql/queries
 
 go 1.21
+
+require github.com/fsnotify/fsnotify v1.8.0
+
+require golang.org/x/sys v0.13.0 // indirect
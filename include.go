@@ -0,0 +1,147 @@
+package queries
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// unknownIncludeError is the include error of a query including one that
+// isn't loaded (yet)
+type unknownIncludeError struct {
+	query, include string
+}
+
+func (e *unknownIncludeError) Error() string {
+	return fmt.Sprintf("Query '%s' includes unknown query '%s'", e.query, e.include)
+}
+
+// resolveIncludes replaces the "-- include: name" lines of every query with
+// the body of the named query and parses the assembled text again. It runs
+// after every load, so a query may include one from a file loaded later.
+func (s *QueryStore) resolveIncludes() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.resolveIncludesLocked()
+}
+
+// resolveIncludesLocked is resolveIncludes for callers holding the write
+// lock. A query that can't be resolved stays loaded with its include error,
+// which Query returns, and is retried by the next load. The first new
+// error is returned, except for unknown includes as their query may be
+// loaded later.
+func (s *QueryStore) resolveIncludesLocked() error {
+	var names []string
+	for name, q := range s.queries {
+		if q.source != "" {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	var failed error
+	for _, name := range names {
+		q := s.queries[name]
+		resolved, err := s.resolve(q)
+		if err != nil {
+			if q.includeErr != nil && q.includeErr.Error() == err.Error() {
+				continue
+			}
+
+			var unknown *unknownIncludeError
+			if failed == nil && !errors.As(err, &unknown) {
+				failed = err
+			}
+			// loaded queries are shared, e.g. by Merge, so the error goes
+			// on a copy
+			resolved = q.Clone()
+			resolved.includeErr = err
+		} else if resolved == q {
+			if q.includeErr == nil {
+				continue
+			}
+			resolved = q.Clone()
+			resolved.includeErr = nil
+		}
+
+		// the aliases are unchanged, only the statement or error differs
+		s.queries[name] = resolved
+		s.closeStmts(name)
+	}
+
+	return failed
+}
+
+// resolve returns q parsed again from its assembled text, or q itself when
+// the text didn't change
+func (s *QueryStore) resolve(q *Query) (*Query, error) {
+	text, err := s.assemble(q, []string{q.Name})
+	if err != nil {
+		return nil, err
+	}
+	if text == q.Raw {
+		return q, nil
+	}
+
+	resolved, err := newQuery(q.Name, text, s.opts)
+	if err != nil {
+		return nil, err
+	}
+	resolved.Path = q.Path
+	resolved.Aliases = q.Aliases
+	resolved.ParamTypes = q.ParamTypes
+	resolved.source = q.source
+	if err := resolved.setMetadata(q.Metadata, q.metadataOriginal, s.opts); err != nil {
+		return nil, err
+	}
+
+	if s.opts.strictParams {
+		if err := resolved.checkDeclaredParams(); err != nil {
+			return nil, err
+		}
+	}
+
+	return resolved, nil
+}
+
+// assemble returns the body of q with its includes inlined recursively.
+// chain holds the names of the queries being assembled, outermost first.
+func (s *QueryStore) assemble(q *Query, chain []string) (string, error) {
+	if q.source == "" {
+		return q.Raw, nil
+	}
+
+	var err error
+	text := includeTagRE.ReplaceAllStringFunc(q.source, func(line string) string {
+		if err != nil {
+			return line
+		}
+
+		name := getDirective(includeTagRE, line)
+		included, ok := s.lookup(name)
+		if !ok {
+			err = &unknownIncludeError{query: q.Name, include: name}
+			return line
+		}
+
+		next := append(append([]string{}, chain...), included.Name)
+		for _, seen := range chain {
+			if seen == included.Name {
+				err = fmt.Errorf("Include cycle: %s", strings.Join(next, " -> "))
+				return line
+			}
+		}
+		if len(chain) > s.opts.maxIncludeDepth {
+			err = fmt.Errorf("Includes nested deeper than %d: %s", s.opts.maxIncludeDepth, strings.Join(next, " -> "))
+			return line
+		}
+
+		var body string
+		body, err = s.assemble(included, next)
+		return body
+	})
+
+	return text, err
+}
@@ -0,0 +1,217 @@
+package queries
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestInclude(t *testing.T) {
+	content := "-- name: active-users\nactive_users AS (SELECT * FROM users WHERE org_id = :org_id AND active)\n\n" +
+		"-- name: user-ctes\nWITH\n-- include: active-users\n\n" +
+		"-- name: list-users\n-- include: user-ctes\nSELECT * FROM active_users WHERE name = :name\n\n" +
+		"-- name: count-users\n/* include: active-users */\nSELECT 1\n"
+
+	s := NewQueryStore()
+	if err := s.LoadFromString("users.sql", content); err != nil {
+		t.Fatalf("LoadFromString: %v", err)
+	}
+
+	testCases := []struct {
+		name         string
+		expectedSQL  string
+		expectedArgs []string
+	}{
+		{
+			name:         "user-ctes",
+			expectedSQL:  "WITH\nactive_users AS (SELECT * FROM users WHERE org_id = $1 AND active)",
+			expectedArgs: []string{"org_id"},
+		},
+		{
+			name:         "list-users",
+			expectedSQL:  "WITH\nactive_users AS (SELECT * FROM users WHERE org_id = $1 AND active)\nSELECT * FROM active_users WHERE name = $2",
+			expectedArgs: []string{"org_id", "name"},
+		},
+		{
+			name:         "count-users",
+			expectedSQL:  "active_users AS (SELECT * FROM users WHERE org_id = $1 AND active)\nSELECT 1",
+			expectedArgs: []string{"org_id"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			q := s.MustHaveQuery(tc.name)
+			if sql := q.SQL(); sql != tc.expectedSQL {
+				t.Errorf("SQL: got %q, expected %q", sql, tc.expectedSQL)
+			}
+			if !reflect.DeepEqual(q.Args, tc.expectedArgs) {
+				t.Errorf("Args: got %v, expected %v", q.Args, tc.expectedArgs)
+			}
+		})
+	}
+}
+
+func TestIncludeAcrossFiles(t *testing.T) {
+	dir := t.TempDir()
+	// reports.sql is loaded before the file it includes from
+	writeSQLFile(t, dir, "reports.sql", "-- name: report\n-- include: base-filter\nSELECT count(*) FROM filtered\n")
+	writeSQLFile(t, dir, "shared.sql", "-- name: base-filter\nWITH filtered AS (SELECT * FROM events WHERE day = :day)\n")
+
+	s := NewQueryStore()
+	if err := s.LoadFromDir(dir); err != nil {
+		t.Fatalf("LoadFromDir: %v", err)
+	}
+
+	expected := "WITH filtered AS (SELECT * FROM events WHERE day = $1)\nSELECT count(*) FROM filtered"
+	if sql := s.MustHaveQuery("report").SQL(); sql != expected {
+		t.Errorf("SQL: got %q, expected %q", sql, expected)
+	}
+}
+
+func TestIncludeErrors(t *testing.T) {
+	testCases := []struct {
+		name     string
+		content  string
+		opts     []Option
+		expected string
+	}{
+		{
+			name:     "cycle",
+			content:  "-- name: a\n-- include: b\nSELECT 1\n\n-- name: b\n-- include: c\n\n-- name: c\n-- include: a\n",
+			expected: "Include cycle: a -> b -> c -> a",
+		},
+		{
+			name:     "self",
+			content:  "-- name: a\n-- include: a\nSELECT 1\n",
+			expected: "Include cycle: a -> a",
+		},
+		{
+			name:     "too deep",
+			content:  "-- name: a\n-- include: b\n\n-- name: b\n-- include: c\n\n-- name: c\nSELECT 1\n",
+			opts:     []Option{WithMaxIncludeDepth(1)},
+			expected: "Includes nested deeper than 1: a -> b -> c",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			s := NewQueryStore(tc.opts...)
+			err := s.LoadFromString("queries.sql", tc.content)
+			if err == nil || !strings.Contains(err.Error(), tc.expected) {
+				t.Errorf("got %v, expected %q", err, tc.expected)
+			}
+
+			// the broken query stays unusable without failing later loads
+			if _, err := s.Query("a"); err == nil || !strings.Contains(err.Error(), tc.expected) {
+				t.Errorf("Query: got %v, expected %q", err, tc.expected)
+			}
+			if err := s.LoadFromString("other.sql", "-- name: other\nSELECT 2\n"); err != nil {
+				t.Errorf("unrelated load: %v", err)
+			}
+		})
+	}
+}
+
+func TestIncludeLoadedLater(t *testing.T) {
+	s := NewQueryStore()
+	if err := s.LoadFromString("reports.sql", "-- name: report\n-- include: base\nSELECT count(*) FROM filtered\n"); err != nil {
+		t.Fatalf("loading the including file first: %v", err)
+	}
+
+	expected := "Query 'report' includes unknown query 'base'"
+	if _, err := s.Query("report"); err == nil || err.Error() != expected {
+		t.Errorf("Query before the include is loaded: got %v, expected %q", err, expected)
+	}
+	if err := s.LoadFromString("other.sql", "-- name: other\nSELECT 1\n"); err != nil {
+		t.Errorf("unrelated load: %v", err)
+	}
+	if issues := s.Lint(); len(issues) != 1 || issues[0].Message != expected {
+		t.Errorf("Lint: got %v", issues)
+	}
+
+	if err := s.LoadFromString("shared.sql", "-- name: base\nWITH filtered AS (SELECT * FROM events WHERE day = :day)\n"); err != nil {
+		t.Fatalf("loading the included file: %v", err)
+	}
+	q, err := s.Query("report")
+	if err != nil {
+		t.Fatalf("Query after the include is loaded: %v", err)
+	}
+	if sql := q.SQL(); sql != "WITH filtered AS (SELECT * FROM events WHERE day = $1)\nSELECT count(*) FROM filtered" {
+		t.Errorf("SQL: got %q", sql)
+	}
+}
+
+func TestIncludeDepthLimit(t *testing.T) {
+	// chain returns n includes nested in each other, q0 -> q1 -> ... -> qn
+	chain := func(n int) string {
+		var b strings.Builder
+		for i := 0; i < n; i++ {
+			fmt.Fprintf(&b, "-- name: q%d\n-- include: q%d\n\n", i, i+1)
+		}
+		fmt.Fprintf(&b, "-- name: q%d\nSELECT 1\n", n)
+		return b.String()
+	}
+
+	testCases := []struct {
+		name    string
+		content string
+		opts    []Option
+		fails   bool
+	}{
+		{name: "at the limit", content: chain(1), opts: []Option{WithMaxIncludeDepth(1)}},
+		{name: "over the limit", content: chain(2), opts: []Option{WithMaxIncludeDepth(1)}, fails: true},
+		{name: "at the default limit", content: chain(DefaultMaxIncludeDepth)},
+		{name: "over the default limit", content: chain(DefaultMaxIncludeDepth + 1), fails: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			s := NewQueryStore(tc.opts...)
+			err := s.LoadFromString("queries.sql", tc.content)
+			if tc.fails {
+				if err == nil || !strings.Contains(err.Error(), "Includes nested deeper than") {
+					t.Errorf("got %v, expected a nesting error", err)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("LoadFromString: %v", err)
+			}
+			if sql := s.MustHaveQuery("q0").SQL(); sql != "SELECT 1" {
+				t.Errorf("SQL: got %q", sql)
+			}
+		})
+	}
+}
+
+// TestMergeUnresolvedInclude runs under -race: Merge shares the loaded
+// queries of the other store, which must not change them
+func TestMergeUnresolvedInclude(t *testing.T) {
+	lib := NewQueryStore()
+	if err := lib.LoadFromString("lib.sql", "-- name: list\n-- include: missing\nSELECT 1\n"); err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			lib.Query("list")
+		}
+	}()
+
+	for i := 0; i < 100; i++ {
+		app := NewQueryStore()
+		if err := app.Merge(lib); err != nil {
+			t.Fatalf("Merge: %v", err)
+		}
+	}
+	<-done
+
+	if _, err := lib.Query("list"); err == nil || !strings.Contains(err.Error(), "unknown query 'missing'") {
+		t.Errorf("Query: got %v, expected the include error", err)
+	}
+}
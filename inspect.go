@@ -0,0 +1,510 @@
+package queries
+
+import (
+	"bytes"
+	"strings"
+)
+
+// stripSQLComments blanks out "--" line comments and "/* */" block
+// comments. Comment characters are replaced by spaces (newlines are kept)
+// so offsets and lines in the result match the original query. Comment
+// markers inside quoted literals, identifiers and dollar-quoted strings are
+// left alone.
+func stripSQLComments(query string) string {
+	b := []byte(query)
+
+	for i := 0; i < len(b); i++ {
+		switch b[i] {
+		case '\'', '"':
+			i = skipQuoted(b, i)
+		case '-':
+			if i+1 < len(b) && b[i+1] == '-' {
+				for ; i < len(b) && b[i] != '\n'; i++ {
+					b[i] = ' '
+				}
+			}
+		case '/':
+			if i+1 < len(b) && b[i+1] == '*' {
+				i = blankBlockComment(b, i)
+			}
+		case '$':
+			// comment markers in function bodies are part of the body
+			i, _ = skipDollarQuoted(b, i)
+		}
+	}
+
+	return string(b)
+}
+
+// removeSQLComments deletes the comments of the query. Block comments
+// between two tokens leave a single space, and lines holding nothing but
+// comments are dropped.
+func removeSQLComments(query string) string {
+	b := []byte(query)
+
+	var out strings.Builder
+	// touched holds the output lines a comment was removed from
+	touched := map[int]bool{}
+	line, last := 0, 0
+	cut := func(start, end int) {
+		out.WriteString(query[last:start])
+		line += strings.Count(query[last:start], "\n")
+		touched[line] = true
+		last = end
+	}
+
+	for i := 0; i < len(b); i++ {
+		switch b[i] {
+		case '\'', '"':
+			i = skipQuoted(b, i)
+		case '-':
+			if i+1 < len(b) && b[i+1] == '-' {
+				start := i
+				for i < len(b) && b[i] != '\n' {
+					i++
+				}
+				cut(start, i)
+			}
+		case '/':
+			if i+1 < len(b) && b[i+1] == '*' {
+				start := i
+				i = blankBlockComment(b, i)
+				end := min(i+1, len(b))
+				cut(start, end)
+
+				switch {
+				case start == 0 || end == len(b):
+				case !isSpace(b[start-1]) && !isSpace(b[end]):
+					out.WriteByte(' ')
+				case isSpace(b[start-1]) && (b[end] == ' ' || b[end] == '\t'):
+					// keep a single space around the comment
+					last++
+				}
+			}
+		case '$':
+			i, _ = skipDollarQuoted(b, i)
+		}
+	}
+	out.WriteString(query[last:])
+
+	lines := strings.Split(out.String(), "\n")
+	kept := lines[:0]
+	for n, l := range lines {
+		if touched[n] {
+			if l = strings.TrimRight(l, " \t\r"); strings.TrimSpace(l) == "" {
+				continue
+			}
+		}
+		kept = append(kept, l)
+	}
+
+	return strings.Join(kept, "\n")
+}
+
+// blankBlockComment blanks the block comment starting at i, including
+// nested ones, and returns the index of its last character. An unterminated
+// comment runs to the end of the input.
+func blankBlockComment(b []byte, i int) int {
+	depth := 0
+
+	for ; i < len(b); i++ {
+		switch {
+		case b[i] == '/' && i+1 < len(b) && b[i+1] == '*':
+			depth++
+			b[i], b[i+1] = ' ', ' '
+			i++
+		case b[i] == '*' && i+1 < len(b) && b[i+1] == '/':
+			depth--
+			b[i], b[i+1] = ' ', ' '
+			i++
+			if depth == 0 {
+				return i
+			}
+		case b[i] != '\n':
+			b[i] = ' '
+		}
+	}
+
+	return len(b)
+}
+
+// maskLiterals blanks out quoted literals and identifiers as well as
+// dollar-quoted strings, keeping offsets
+func maskLiterals(query string) string {
+	return maskQuoted(query, true)
+}
+
+// maskDollarQuoted blanks out dollar-quoted strings, e.g. function bodies,
+// keeping offsets
+func maskDollarQuoted(query string) string {
+	return maskQuoted(query, false)
+}
+
+func maskQuoted(query string, literals bool) string {
+	b := []byte(query)
+
+	blank := func(start, end int) {
+		for i := start; i <= end && i < len(b); i++ {
+			b[i] = ' '
+		}
+	}
+
+	for i := 0; i < len(b); i++ {
+		switch b[i] {
+		case '\'', '"':
+			end := skipQuoted(b, i)
+			if literals {
+				blank(i, end)
+			}
+			i = end
+		case '$':
+			if end, ok := skipDollarQuoted(b, i); ok {
+				blank(i, end)
+				i = end
+			}
+		}
+	}
+
+	return string(b)
+}
+
+// skipQuoted returns the index of the closing quote for the literal or
+// identifier starting at i. Doubled quotes are treated as escapes.
+func skipQuoted(b []byte, i int) int {
+	quote := b[i]
+	for i++; i < len(b); i++ {
+		if b[i] == quote {
+			if i+1 < len(b) && b[i+1] == quote {
+				i++
+				continue
+			}
+			return i
+		}
+	}
+
+	return len(b)
+}
+
+// skipDollarQuoted returns the index of the last byte of the dollar-quoted
+// string ($$...$$ or $tag$...$tag$) starting at i, or len(b) when it is
+// unterminated. The bool is false when no dollar quote starts at i.
+func skipDollarQuoted(b []byte, i int) (int, bool) {
+	// "$" inside an identifier, e.g. "a$b", doesn't start a quote
+	if i > 0 && isIdentChar(b[i-1]) {
+		return i, false
+	}
+
+	end := i + 1
+	if end < len(b) && isIdentStart(b[end]) {
+		for end < len(b) && isIdentChar(b[end]) && b[end] != '$' {
+			end++
+		}
+	}
+	if end >= len(b) || b[end] != '$' {
+		return i, false
+	}
+
+	tag := b[i : end+1]
+	closing := bytes.Index(b[end+1:], tag)
+	if closing < 0 {
+		return len(b), true
+	}
+
+	return end + closing + len(tag), true
+}
+
+// CTEs returns the names of the common table expressions defined by the
+// leading WITH clause of the query
+func (q *Query) CTEs() []string {
+	c := &sqlCursor{src: stripSQLComments(q.Raw)}
+	c.skipSpace()
+
+	return c.withClause()
+}
+
+// Verb returns the uppercased leading keyword of the statement (SELECT,
+// INSERT, CREATE, ...). Leading comments are skipped and a WITH clause
+// resolves to the verb of the statement that follows it.
+func (q *Query) Verb() string {
+	if q.verb == "" && q.Raw != "" {
+		return statementVerb(q.Raw)
+	}
+
+	return q.verb
+}
+
+func statementVerb(query string) string {
+	c := &sqlCursor{src: stripSQLComments(query)}
+
+	c.skipSpace()
+	for c.peek() == '(' {
+		c.pos++
+		c.skipSpace()
+	}
+
+	c.withClause()
+	c.skipSpace()
+
+	start := c.pos
+	for c.pos < len(c.src) && isIdentStart(c.src[c.pos]) {
+		c.pos++
+	}
+
+	return strings.ToUpper(c.src[start:c.pos])
+}
+
+// withClause consumes a WITH clause at the cursor and returns the names of
+// the defined CTEs
+func (c *sqlCursor) withClause() []string {
+	if !c.keyword("WITH") {
+		return nil
+	}
+	c.skipSpace()
+	c.keyword("RECURSIVE")
+
+	var names []string
+	for {
+		c.skipSpace()
+		name := c.identifier()
+		if name == "" {
+			break
+		}
+
+		// optional column list
+		c.skipSpace()
+		if c.peek() == '(' {
+			c.skipParens()
+			c.skipSpace()
+		}
+
+		if !c.keyword("AS") {
+			break
+		}
+		c.skipSpace()
+		if c.keyword("NOT") {
+			c.skipSpace()
+		}
+		if c.keyword("MATERIALIZED") {
+			c.skipSpace()
+		}
+		if c.peek() != '(' {
+			break
+		}
+		c.skipParens()
+		names = append(names, name)
+
+		c.skipSpace()
+		if c.peek() != ',' {
+			break
+		}
+		c.pos++
+	}
+
+	return names
+}
+
+// sqlCursor is a minimal forward-only reader over SQL text
+type sqlCursor struct {
+	src string
+	pos int
+}
+
+func (c *sqlCursor) peek() byte {
+	if c.pos >= len(c.src) {
+		return 0
+	}
+	return c.src[c.pos]
+}
+
+func (c *sqlCursor) skipSpace() {
+	for c.pos < len(c.src) && isSpace(c.src[c.pos]) {
+		c.pos++
+	}
+}
+
+// keyword consumes kw (case insensitive) when it appears as a whole word
+func (c *sqlCursor) keyword(kw string) bool {
+	end := c.pos + len(kw)
+	if end > len(c.src) || !strings.EqualFold(c.src[c.pos:end], kw) {
+		return false
+	}
+	if end < len(c.src) && isIdentChar(c.src[end]) {
+		return false
+	}
+
+	c.pos = end
+	return true
+}
+
+// identifier consumes a bare or double-quoted identifier
+func (c *sqlCursor) identifier() string {
+	if c.peek() == '"' {
+		end := skipQuoted([]byte(c.src), c.pos)
+		if end >= len(c.src) {
+			return ""
+		}
+		name := strings.ReplaceAll(c.src[c.pos+1:end], `""`, `"`)
+		c.pos = end + 1
+		return name
+	}
+
+	start := c.pos
+	for c.pos < len(c.src) && isIdentChar(c.src[c.pos]) {
+		c.pos++
+	}
+
+	return c.src[start:c.pos]
+}
+
+// skipParens consumes a balanced parenthesized group, ignoring parentheses
+// inside quoted literals
+func (c *sqlCursor) skipParens() {
+	b := []byte(c.src)
+	depth := 0
+
+	for ; c.pos < len(b); c.pos++ {
+		switch b[c.pos] {
+		case '\'', '"':
+			c.pos = skipQuoted(b, c.pos)
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				c.pos++
+				return
+			}
+		}
+	}
+}
+
+func isSpace(ch byte) bool {
+	return ch == ' ' || ch == '\t' || ch == '\n' || ch == '\r' || ch == '\f'
+}
+
+func isIdentChar(ch byte) bool {
+	return ch == '_' || ch == '$' || (ch >= 'a' && ch <= 'z') || (ch >= 'A' && ch <= 'Z') || (ch >= '0' && ch <= '9') || ch >= 0x80
+}
+
+// Skeleton returns the shape of the query: comments removed, parameters
+// and literals replaced by "?", whitespace collapsed and everything outside
+// quoted identifiers lowercased. Queries that only differ in parameter
+// names or literal values share the same skeleton.
+func (q *Query) Skeleton() string {
+	src := stripSQLComments(q.Raw)
+
+	var b strings.Builder
+	space := false
+	emit := func(s string) {
+		if space && b.Len() > 0 {
+			b.WriteByte(' ')
+		}
+		space = false
+		b.WriteString(s)
+	}
+
+	for i := 0; i < len(src); i++ {
+		ch := src[i]
+
+		switch {
+		case isSpace(ch):
+			space = true
+		case ch == '\'':
+			i = skipQuoted([]byte(src), i)
+			emit("?")
+		case ch == '"':
+			end := skipQuoted([]byte(src), i)
+			if end >= len(src) {
+				end = len(src) - 1
+			}
+			emit(src[i : end+1])
+			i = end
+		case ch == ':' && i+1 < len(src) && src[i+1] == ':':
+			// type cast
+			emit("::")
+			i++
+		case ch == ':' && i+1 < len(src) && (isIdentStart(src[i+1]) || src[i+1] == '\'' || src[i+1] == '"'):
+			i++
+			if src[i] == '\'' || src[i] == '"' {
+				i = skipQuoted([]byte(src), i)
+			} else {
+				for i+1 < len(src) && isIdentChar(src[i+1]) {
+					i++
+				}
+			}
+			emit("?")
+		case ch == '$' && i+1 < len(src) && isDigit(src[i+1]):
+			for i+1 < len(src) && isDigit(src[i+1]) {
+				i++
+			}
+			emit("?")
+		case isDigit(ch):
+			for i+1 < len(src) && (isDigit(src[i+1]) || src[i+1] == '.') {
+				i++
+			}
+			emit("?")
+		case isIdentChar(ch):
+			start := i
+			for i+1 < len(src) && isIdentChar(src[i+1]) {
+				i++
+			}
+			emit(strings.ToLower(src[start : i+1]))
+		default:
+			emit(string(ch))
+		}
+	}
+
+	return b.String()
+}
+
+// Statements splits the query on the semicolons ending its statements.
+// Semicolons in literals, comments and dollar-quoted bodies are kept, and
+// empty statements are dropped. Parameters keep the numbering of the whole
+// query.
+func (q *Query) Statements() []string {
+	src := q.SQL()
+	b := []byte(src)
+
+	var statements []string
+	add := func(statement string) {
+		if statement = strings.TrimSpace(statement); statement != "" {
+			statements = append(statements, statement)
+		}
+	}
+
+	start := 0
+	for i := 0; i < len(b); i++ {
+		switch b[i] {
+		case '\'', '"':
+			i = skipQuoted(b, i)
+		case '-':
+			if i+1 < len(b) && b[i+1] == '-' {
+				for i < len(b) && b[i] != '\n' {
+					i++
+				}
+			}
+		case '/':
+			if i+1 < len(b) && b[i+1] == '*' {
+				i = blankBlockComment(b, i)
+			}
+		case '$':
+			i, _ = skipDollarQuoted(b, i)
+		case ';':
+			add(src[start:i])
+			start = i + 1
+		}
+	}
+	if start < len(src) {
+		add(src[start:])
+	}
+
+	return statements
+}
+
+func isIdentStart(ch byte) bool {
+	return ch == '_' || (ch >= 'a' && ch <= 'z') || (ch >= 'A' && ch <= 'Z')
+}
+
+func isDigit(ch byte) bool {
+	return ch >= '0' && ch <= '9'
+}
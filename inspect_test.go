@@ -0,0 +1,225 @@
+package queries
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestStripSQLComments(t *testing.T) {
+	testCases := []struct {
+		name     string
+		query    string
+		expected string
+	}{
+		{name: "no comments", query: "SELECT 1", expected: "SELECT 1"},
+		{name: "trailing comment", query: "SELECT 1 -- one", expected: "SELECT 1       "},
+		{name: "comment line", query: "-- :id\nSELECT 1", expected: "      \nSELECT 1"},
+		{name: "marker in literal", query: "SELECT '--not' -- yes", expected: "SELECT '--not'       "},
+		{name: "escaped quote", query: "SELECT 'it''s' -- x", expected: "SELECT 'it''s'     "},
+		{name: "block comment", query: "SELECT /* :fake */ id", expected: "SELECT             id"},
+		{name: "multi-line block comment", query: "SELECT /* $1\n@param */ id", expected: "SELECT      \n          id"},
+		{name: "nested block comment", query: "SELECT /* a /* :b */ c */ 1", expected: "SELECT                    1"},
+		{name: "unterminated block comment", query: "SELECT 1 /* :id", expected: "SELECT 1       "},
+		{name: "block marker in literal", query: "SELECT '/*' , :id", expected: "SELECT '/*' , :id"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			result := stripSQLComments(tc.query)
+			if result != tc.expected {
+				t.Errorf("stripSQLComments: got %q, expected %q", result, tc.expected)
+			}
+		})
+	}
+}
+
+func TestCTEs(t *testing.T) {
+	testCases := []struct {
+		name     string
+		query    string
+		expected []string
+	}{
+		{
+			name:     "no cte",
+			query:    "SELECT * FROM users",
+			expected: nil,
+		},
+		{
+			name:     "single",
+			query:    "WITH recent AS (SELECT * FROM orders WHERE created_at > now() - interval '1 day') SELECT * FROM recent",
+			expected: []string{"recent"},
+		},
+		{
+			name: "multiple",
+			query: `WITH recent AS (
+  SELECT * FROM orders WHERE note <> ')'
+), totals (user_id, total) AS MATERIALIZED (
+  SELECT user_id, sum(amount) FROM recent GROUP BY user_id
+)
+SELECT * FROM totals`,
+			expected: []string{"recent", "totals"},
+		},
+		{
+			name:     "recursive",
+			query:    "with recursive tree as (select id from nodes where parent_id is null union all select n.id from nodes n join tree t on n.parent_id = t.id) select * from tree",
+			expected: []string{"tree"},
+		},
+		{
+			name:     "leading comment",
+			query:    "-- WITH fake AS (SELECT 1)\nWITH \"Real\" AS NOT MATERIALIZED (SELECT 1) SELECT * FROM \"Real\"",
+			expected: []string{"Real"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			result := mustNewQuery(t, tc.name, tc.query).CTEs()
+			if !reflect.DeepEqual(result, tc.expected) {
+				t.Errorf("CTEs: got %v, expected %v", result, tc.expected)
+			}
+		})
+	}
+}
+
+func TestSkeleton(t *testing.T) {
+	skeleton := func(query string) string {
+		return mustNewQuery(t, "skeleton", query).Skeleton()
+	}
+
+	testCases := []struct {
+		name  string
+		a, b  string
+		equal bool
+	}{
+		{
+			name:  "different parameter names",
+			a:     "SELECT * FROM users WHERE id = :id",
+			b:     "select *\n  from users\n where id = :user_id -- lookup",
+			equal: true,
+		},
+		{
+			name:  "literals and positional parameters",
+			a:     "SELECT * FROM users WHERE status = 'active' AND age > 18 AND id = $1",
+			b:     "SELECT * FROM users WHERE status = 'banned' AND age > 21 AND id = :id",
+			equal: true,
+		},
+		{
+			name:  "casts are kept",
+			a:     "SELECT id::text FROM users WHERE id = :id::int",
+			b:     "SELECT id::text FROM users WHERE id = :other::int",
+			equal: true,
+		},
+		{
+			name:  "different operator",
+			a:     "SELECT * FROM users WHERE id = :id",
+			b:     "SELECT * FROM users WHERE id > :id",
+			equal: false,
+		},
+		{
+			name:  "extra condition",
+			a:     "SELECT * FROM users WHERE id = :id",
+			b:     "SELECT * FROM users WHERE id = :id AND deleted_at IS NULL",
+			equal: false,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			a, b := skeleton(tc.a), skeleton(tc.b)
+			if (a == b) != tc.equal {
+				t.Errorf("Skeleton: %q vs %q, expected equal=%v", a, b, tc.equal)
+			}
+		})
+	}
+
+	expected := "select * from users where id = ? and name = ?"
+	if result := skeleton("SELECT *  FROM users\nWHERE id = :id AND name = 'x'"); result != expected {
+		t.Errorf("Skeleton: got %q, expected %q", result, expected)
+	}
+}
+
+func TestVerb(t *testing.T) {
+	testCases := []struct {
+		name     string
+		query    string
+		expected string
+	}{
+		{name: "select", query: "SELECT * FROM users", expected: "SELECT"},
+		{name: "insert", query: "insert into users (name) values (:name)", expected: "INSERT"},
+		{name: "with delete", query: "WITH stale AS (SELECT id FROM sessions WHERE expires_at < now()) DELETE FROM sessions WHERE id IN (SELECT id FROM stale)", expected: "DELETE"},
+		{name: "create table", query: "CREATE TABLE users (id int)", expected: "CREATE"},
+		{name: "leading comment", query: "-- remove old rows\n  UPDATE users SET deleted_at = now()", expected: "UPDATE"},
+		{name: "parenthesized", query: "(SELECT 1) UNION (SELECT 2)", expected: "SELECT"},
+		{name: "empty", query: "", expected: ""},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			q := mustNewQuery(t, tc.name, tc.query)
+			if verb := q.Verb(); verb != tc.expected {
+				t.Errorf("Verb: got %q, expected %q", verb, tc.expected)
+			}
+			if q.verb != tc.expected {
+				t.Errorf("verb was not cached: %q", q.verb)
+			}
+		})
+	}
+}
+
+func TestStatements(t *testing.T) {
+	testCases := []struct {
+		name     string
+		query    string
+		expected []string
+	}{
+		{
+			name:     "two statements",
+			query:    "CREATE TABLE users (id int);\nINSERT INTO users VALUES (:id)",
+			expected: []string{"CREATE TABLE users (id int)", "INSERT INTO users VALUES ($1)"},
+		},
+		{
+			name:     "trailing semicolon",
+			query:    "UPDATE users SET name = :name;\nDELETE FROM sessions;\n",
+			expected: []string{"UPDATE users SET name = $1", "DELETE FROM sessions"},
+		},
+		{
+			name:     "semicolon in literal",
+			query:    "INSERT INTO notes VALUES ('a; b', \"c;d\"); SELECT 1",
+			expected: []string{"INSERT INTO notes VALUES ('a; b', \"c;d\")", "SELECT 1"},
+		},
+		{
+			name:     "semicolon in comments",
+			query:    "SELECT 1 -- first; second\n/* not; here */;\nSELECT 2",
+			expected: []string{"SELECT 1 -- first; second\n/* not; here */", "SELECT 2"},
+		},
+		{
+			name:     "dollar quoted body",
+			query:    "CREATE FUNCTION f() RETURNS int AS $body$ BEGIN RETURN 1; END $body$ LANGUAGE plpgsql;\nSELECT f()",
+			expected: []string{"CREATE FUNCTION f() RETURNS int AS $body$ BEGIN RETURN 1; END $body$ LANGUAGE plpgsql", "SELECT f()"},
+		},
+		{
+			name:     "anonymous dollar quote",
+			query:    "DO $$ BEGIN PERFORM 1; END $$; SELECT $1",
+			expected: []string{"DO $$ BEGIN PERFORM 1; END $$", "SELECT $1"},
+		},
+		{
+			name:     "single statement",
+			query:    "SELECT 1",
+			expected: []string{"SELECT 1"},
+		},
+		{
+			name:     "empty statements",
+			query:    ";;SELECT 1;;",
+			expected: []string{"SELECT 1"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			statements := mustNewQuery(t, tc.name, tc.query).Statements()
+			if !reflect.DeepEqual(statements, tc.expected) {
+				t.Errorf("Statements: got %q, expected %q", statements, tc.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,98 @@
+package queries
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// jsonStore is the serialized form of a QueryStore
+type jsonStore struct {
+	Queries []jsonQuery
+}
+
+type jsonQuery struct {
+	Name             string
+	Aliases          []string `json:",omitempty"`
+	Path             string
+	Raw              string
+	OrdinalQuery     string
+	Mapping          map[string]int
+	Args             []string
+	NamedArgs        []sql.NamedArg
+	Metadata         map[string]string
+	MetadataOriginal map[string]string
+	ParamTypes       map[string]string `json:",omitempty"`
+}
+
+// MarshalJSON serializes the loaded queries in name order. The result can
+// be loaded with LoadFromJSON to skip scanning the files at startup.
+func (s *QueryStore) MarshalJSON() ([]byte, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	out := jsonStore{Queries: make([]jsonQuery, 0, len(s.queries))}
+	for _, q := range s.queriesSorted() {
+		out.Queries = append(out.Queries, jsonQuery{
+			Name:             q.Name,
+			Aliases:          q.Aliases,
+			Path:             q.Path,
+			Raw:              q.Raw,
+			OrdinalQuery:     q.OrdinalQuery,
+			Mapping:          q.Mapping,
+			Args:             q.Args,
+			NamedArgs:        q.NamedArgs,
+			Metadata:         q.Metadata,
+			MetadataOriginal: q.metadataOriginal,
+			ParamTypes:       q.ParamTypes,
+		})
+	}
+
+	return json.Marshal(out)
+}
+
+// LoadFromJSON adds the queries serialized by MarshalJSON. The queries are
+// parsed again from Raw with the options of the store, so the store should
+// be created with the same options as the serialized one. Preprocessor and
+// syntax validator are not run, Raw already went through them.
+func (s *QueryStore) LoadFromJSON(r io.Reader) error {
+	var in jsonStore
+	if err := json.NewDecoder(r).Decode(&in); err != nil {
+		return fmt.Errorf("decoding queries: %w", err)
+	}
+
+	names := make([]string, 0, len(in.Queries))
+	queries := make(map[string]*Query, len(in.Queries))
+	for _, jq := range in.Queries {
+		if _, ok := queries[jq.Name]; ok {
+			return fmt.Errorf("Query '%s' is serialized twice", jq.Name)
+		}
+
+		q, err := newQuery(jq.Name, jq.Raw, s.opts)
+		if err != nil {
+			return err
+		}
+		q.Path = jq.Path
+		q.Aliases = jq.Aliases
+		q.ParamTypes = jq.ParamTypes
+
+		if err := q.setMetadata(jq.Metadata, jq.MetadataOriginal, s.opts); err != nil {
+			return err
+		}
+
+		names = append(names, jq.Name)
+		queries[jq.Name] = q
+	}
+
+	before := s.Len()
+
+	s.mu.Lock()
+	err := s.insertAll(names, queries)
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	return s.finishLoad(before)
+}
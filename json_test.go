@@ -0,0 +1,85 @@
+package queries
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestJSONRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	writeSQLFile(t, dir, "users.sql", "-- name: get-user\n-- alias: user-by-id\n-- Description: Fetch a user\n-- expect: exactly-one\nSELECT * FROM users WHERE id = :id AND (owner = :owner OR :owner IS NULL)\n\n"+
+		"-- name: list-users\nSELECT * FROM users LIMIT :n::int\n")
+	writeSQLFile(t, dir, "orders.sql", "-- name: list-orders\nSELECT * FROM orders WHERE user_id = $1\n")
+
+	s := NewQueryStore()
+	if err := s.LoadFromDir(dir); err != nil {
+		t.Fatalf("LoadFromDir: %v", err)
+	}
+
+	data, err := json.Marshal(s)
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+
+	loaded := NewQueryStore()
+	if err := loaded.LoadFromJSON(bytes.NewReader(data)); err != nil {
+		t.Fatalf("LoadFromJSON: %v", err)
+	}
+
+	if !reflect.DeepEqual(loaded.Queries(), s.Queries()) {
+		t.Errorf("queries differ after round trip:\n%v\n%v", loaded.Queries(), s.Queries())
+	}
+
+	q, err := loaded.Query("user-by-id")
+	if err != nil {
+		t.Fatalf("Query by alias: %v", err)
+	}
+	if q.Expect != ExpectExactlyOne {
+		t.Errorf("Expect: got %v", q.Expect)
+	}
+	if description := q.MetadataOriginal()["Description"]; description != "Fetch a user" {
+		t.Errorf("MetadataOriginal: got %q", description)
+	}
+}
+
+func TestLoadFromJSONErrors(t *testing.T) {
+	testCases := []struct {
+		name  string
+		input string
+	}{
+		{name: "malformed", input: `{"Queries": [`},
+		{name: "duplicate", input: `{"Queries": [{"Name": "a", "Raw": "SELECT 1"}, {"Name": "a", "Raw": "SELECT 2"}]}`},
+		{name: "mixed params", input: `{"Queries": [{"Name": "a", "Raw": "SELECT :a, $1"}]}`},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			s := NewQueryStore()
+			if err := s.LoadFromJSON(strings.NewReader(tc.input)); err == nil {
+				t.Errorf("expected error")
+			}
+			if s.Len() != 0 {
+				t.Errorf("loaded %d queries", s.Len())
+			}
+		})
+	}
+}
+
+func TestLoadFromJSONMinQueries(t *testing.T) {
+	s := NewQueryStore(WithMinQueries(2))
+	err := s.LoadFromJSON(strings.NewReader(`{"Queries": [{"Name": "a", "Raw": "SELECT 1"}]}`))
+	if err == nil || !strings.Contains(err.Error(), "expected at least 2") {
+		t.Errorf("LoadFromJSON: got %v, expected WithMinQueries error", err)
+	}
+}
+
+func TestMarshalJSONWhileWriting(t *testing.T) {
+	readWhileWriting(t, func(s *QueryStore) {
+		if _, err := json.Marshal(s); err != nil {
+			t.Errorf("Marshal: %v", err)
+		}
+	})
+}
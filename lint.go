@@ -0,0 +1,334 @@
+package queries
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+)
+
+var (
+	limitParamRE = regexp.MustCompile(`(?i)\b(LIMIT|OFFSET)\s+:['"]?([A-Za-z][A-Za-z0-9_]*)['"]?(::)?`)
+)
+
+// Severity classifies a Warning
+type Severity int
+
+const (
+	SeverityInfo Severity = iota
+	SeverityWarning
+)
+
+func (s Severity) String() string {
+	switch s {
+	case SeverityInfo:
+		return "info"
+	case SeverityWarning:
+		return "warning"
+	}
+
+	return fmt.Sprintf("Severity(%d)", int(s))
+}
+
+// Warning is a non-fatal issue found while loading queries
+type Warning struct {
+	Query    string
+	Path     string
+	Message  string
+	Severity Severity
+}
+
+func (w Warning) String() string {
+	return fmt.Sprintf("%s: %s: %s (%s)", w.Path, w.Query, w.Message, w.Severity)
+}
+
+// Warnings returns the non-fatal issues recorded while loading, in load
+// order
+func (s *QueryStore) Warnings() []Warning {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	warnings := make([]Warning, len(s.warnings))
+	copy(warnings, s.warnings)
+
+	return warnings
+}
+
+// loadWarnings collects the warnings for a freshly parsed query
+func loadWarnings(q *Query, opts options) []Warning {
+	var warnings []Warning
+
+	if hasCommentedParams(q, opts.reserved()) {
+		warnings = append(warnings, Warning{
+			Query:    q.Name,
+			Path:     q.Path,
+			Message:  "placeholder found in a comment but the query has no parameters",
+			Severity: SeverityWarning,
+		})
+	}
+
+	for _, issue := range lintLimitParams(q) {
+		warnings = append(warnings, Warning{
+			Query:    q.Name,
+			Path:     q.Path,
+			Message:  issue.Message,
+			Severity: SeverityInfo,
+		})
+	}
+
+	return warnings
+}
+
+// knownMetadataKeys are the metadata keys Lint accepts without
+// WithMetadataKeys
+var knownMetadataKeys = []string{"description", "expect", "max-cost", "required-nodes", "template", "timeout"}
+
+// LintIssue describes a potential problem found in a loaded query
+type LintIssue struct {
+	Query    string
+	Path     string
+	Param    string
+	Message  string
+	Severity Severity
+}
+
+func (i LintIssue) String() string {
+	if i.Path == "" {
+		return fmt.Sprintf("%s: %s (%s)", i.Query, i.Message, i.Severity)
+	}
+	return fmt.Sprintf("%s: %s: %s (%s)", i.Path, i.Query, i.Message, i.Severity)
+}
+
+// Lint inspects all loaded queries and reports potential problems:
+// parameters passed to LIMIT/OFFSET without a cast, parameter styles that
+// only load because an option is off, unused "-- param:" annotations,
+// parameters bound once but used several times, unknown metadata keys (see
+// WithMetadataKeys), queries without a statement and includes that can't
+// be resolved. Issues are returned sorted by query name.
+func (s *QueryStore) Lint() []LintIssue {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var issues []LintIssue
+	for _, name := range s.sortedNames() {
+		q := s.queries[name]
+
+		var found []LintIssue
+		found = append(found, lintLimitParams(q)...)
+		found = append(found, lintMixedStyles(q, s.opts)...)
+		found = append(found, lintParamTypes(q)...)
+		found = append(found, lintRepeatedParams(q)...)
+		found = append(found, lintMetadataKeys(q, s.opts)...)
+		found = append(found, lintEmpty(q)...)
+		if q.includeErr != nil {
+			found = append(found, LintIssue{Query: q.Name, Message: q.includeErr.Error(), Severity: SeverityWarning})
+		}
+
+		for _, issue := range found {
+			issue.Path = q.Path
+			issues = append(issues, issue)
+		}
+	}
+
+	return issues
+}
+
+// lintLimitParams flags named parameters passed directly to LIMIT/OFFSET.
+// Without an explicit cast some drivers send them as text, which can lead
+// to poor plans.
+func lintLimitParams(q *Query) []LintIssue {
+	var issues []LintIssue
+
+	for _, match := range limitParamRE.FindAllStringSubmatch(q.Raw, -1) {
+		if _, ok := q.Mapping[match[2]]; !ok || match[3] != "" {
+			continue
+		}
+
+		issues = append(issues, LintIssue{
+			Query:    q.Name,
+			Param:    match[2],
+			Message:  fmt.Sprintf("parameter '%s' used in %s without a cast; consider :%s::int", match[2], strings.ToUpper(match[1]), match[2]),
+			Severity: SeverityInfo,
+		})
+	}
+
+	return issues
+}
+
+// lintMixedStyles flags queries that mix parameter styles and only load
+// because WithQuestionMarkParams or WithAtSignParams is off, e.g. a jsonb
+// "?" operator next to :name parameters
+func lintMixedStyles(q *Query, opts options) []LintIssue {
+	opts.questionMarkParams = true
+	opts.atSignParams = true
+
+	var mixed *MixedParameterStyleError
+	if _, err := newQuery(q.Name, q.Raw, opts); !errors.As(err, &mixed) {
+		return nil
+	}
+
+	return []LintIssue{{
+		Query:    q.Name,
+		Message:  fmt.Sprintf("mixes parameter styles %s; loading fails once they are all enabled", strings.Join(mixed.Styles, " and ")),
+		Severity: SeverityInfo,
+	}}
+}
+
+// lintParamTypes flags "-- param:" annotations for parameters the query
+// doesn't use, the problems WithStrictParams fails the load on
+func lintParamTypes(q *Query) []LintIssue {
+	var issues []LintIssue
+
+	for _, name := range sortedKeys(q.ParamTypes) {
+		if _, ok := q.Mapping[name]; ok {
+			continue
+		}
+
+		issues = append(issues, LintIssue{
+			Query:    q.Name,
+			Param:    name,
+			Message:  fmt.Sprintf("parameter '%s' is annotated but not used", name),
+			Severity: SeverityWarning,
+		})
+	}
+
+	return issues
+}
+
+// lintRepeatedParams flags named parameters used several times but bound
+// to a single ordinal marker. This is intended in most queries, but a
+// repeated name can also be a copy-paste mistake for a different one.
+func lintRepeatedParams(q *Query) []LintIssue {
+	if q.Style != StyleColon && q.Style != StyleAtSign {
+		return nil
+	}
+
+	uses := make(map[string]int)
+	ordinals := make(map[string]int)
+	for _, span := range q.spans {
+		uses[span.name]++
+		if ordinals[span.name] == 0 {
+			ordinals[span.name] = span.ordinal
+		} else if ordinals[span.name] != span.ordinal {
+			// expanded with WithExpandRepeatedParams
+			ordinals[span.name] = -1
+		}
+	}
+
+	var issues []LintIssue
+	for _, name := range q.ParamNames() {
+		if uses[name] < 2 || ordinals[name] < 0 {
+			continue
+		}
+
+		issues = append(issues, LintIssue{
+			Query:    q.Name,
+			Param:    name,
+			Message:  fmt.Sprintf("parameter '%s' is used %d times and bound once as $%d", name, uses[name], ordinals[name]),
+			Severity: SeverityInfo,
+		})
+	}
+
+	return issues
+}
+
+// lintMetadataKeys flags metadata keys that are neither used by the package
+// nor added with WithMetadataKeys, usually a typo
+func lintMetadataKeys(q *Query, opts options) []LintIssue {
+	metadata := q.metadataOriginal
+	if metadata == nil {
+		metadata = q.Metadata
+	}
+
+	var issues []LintIssue
+	for _, key := range sortedKeys(metadata) {
+		normalized := normalizeMetadataKey(key)
+		if slices.Contains(knownMetadataKeys, normalized) || slices.Contains(opts.metadataKeys, normalized) {
+			continue
+		}
+
+		issues = append(issues, LintIssue{
+			Query:    q.Name,
+			Message:  fmt.Sprintf("unknown metadata key '%s'", key),
+			Severity: SeverityWarning,
+		})
+	}
+
+	return issues
+}
+
+// lintEmpty flags queries whose body holds only comments and semicolons
+func lintEmpty(q *Query) []LintIssue {
+	for _, statement := range q.Statements() {
+		if strings.TrimSpace(stripSQLComments(statement)) != "" {
+			return nil
+		}
+	}
+
+	return []LintIssue{{
+		Query:    q.Name,
+		Message:  "query has no statements",
+		Severity: SeverityWarning,
+	}}
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+// SuspiciousNoParamQueries returns the names of queries without detected
+// parameters whose text still contains placeholder-looking tokens, usually
+// a parameter left in a comment or written with the wrong sigil
+func (s *QueryStore) SuspiciousNoParamQueries() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var names []string
+	for _, name := range s.sortedNames() {
+		if hasCommentedParams(s.queries[name], s.opts.reserved()) {
+			names = append(names, name)
+		}
+	}
+
+	return names
+}
+
+// hasCommentedParams reports whether the comments of a query without
+// parameters contain :name tokens other than the reserved names. Literals
+// and dollar-quoted bodies are not comments.
+func hasCommentedParams(q *Query, reserved []string) bool {
+	if len(q.Args) > 0 {
+		return false
+	}
+
+	for _, match := range psqlVarRE.FindAllStringSubmatch(commentsOnly(q.Raw), -1) {
+		if !isReservedName(match[1], reserved) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// commentsOnly blanks out everything but the comments of the query,
+// keeping offsets
+func commentsOnly(query string) string {
+	stripped := stripSQLComments(query)
+
+	b := []byte(query)
+	for i := range b {
+		if b[i] == stripped[i] && b[i] != '\n' {
+			b[i] = ' '
+		}
+	}
+
+	return string(b)
+}
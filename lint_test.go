@@ -0,0 +1,248 @@
+package queries
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLintLimitParams(t *testing.T) {
+	testCases := []struct {
+		name     string
+		query    string
+		expected []string
+	}{
+		{name: "limit param", query: "SELECT * FROM users LIMIT :n", expected: []string{"n"}},
+		{name: "limit and offset", query: "SELECT * FROM users LIMIT :n OFFSET :skip", expected: []string{"n", "skip"}},
+		{name: "lowercase", query: "select * from users limit :n", expected: []string{"n"}},
+		{name: "limit literal", query: "SELECT * FROM users LIMIT 10", expected: nil},
+		{name: "limit cast", query: "SELECT * FROM users LIMIT :n::int", expected: nil},
+		{name: "where param", query: "SELECT * FROM users WHERE id = :id", expected: nil},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			issues := lintLimitParams(mustNewQuery(t, tc.name, tc.query))
+			if len(issues) != len(tc.expected) {
+				t.Fatalf("got %d issues (%v), expected %d", len(issues), issues, len(tc.expected))
+			}
+			for i, issue := range issues {
+				if issue.Param != tc.expected[i] || issue.Query != tc.name {
+					t.Errorf("issue %d: got %s/%s, expected %s/%s", i, issue.Query, issue.Param, tc.name, tc.expected[i])
+				}
+			}
+		})
+	}
+}
+
+func TestStoreLint(t *testing.T) {
+	s := NewQueryStore()
+	s.insert("b", mustNewQuery(t, "b", "SELECT * FROM t LIMIT :n"))
+	s.insert("a", mustNewQuery(t, "a", "SELECT * FROM t OFFSET :o"))
+	s.insert("c", mustNewQuery(t, "c", "SELECT * FROM t LIMIT 10"))
+
+	issues := s.Lint()
+	if len(issues) != 2 {
+		t.Fatalf("got %d issues, expected 2", len(issues))
+	}
+	if issues[0].Query != "a" || issues[1].Query != "b" {
+		t.Errorf("issues not sorted by query name: %v", issues)
+	}
+}
+
+func TestLintCategories(t *testing.T) {
+	testCases := []struct {
+		name     string
+		content  string
+		opts     []Option
+		expected []string
+	}{
+		{
+			name:     "mixed styles",
+			content:  "SELECT * FROM docs WHERE owner = :owner AND data ? 'tags'",
+			expected: []string{"mixes parameter styles named (:name) and question mark (?); loading fails once they are all enabled"},
+		},
+		{
+			name:    "mixed styles enabled",
+			content: "SELECT * FROM docs WHERE data ?| :keys",
+			opts:    []Option{WithQuestionMarkParams(true)},
+		},
+		{
+			name:     "unused annotation",
+			content:  "-- param: id int\n-- param: status text\nSELECT * FROM users WHERE id = :id",
+			expected: []string{"parameter 'status' is annotated but not used"},
+		},
+		{
+			name:     "repeated param",
+			content:  "SELECT * FROM users WHERE :name = first_name OR :name = last_name OR :id = id",
+			expected: []string{"parameter 'name' is used 2 times and bound once as $1"},
+		},
+		{
+			name:    "repeated param expanded",
+			content: "SELECT * FROM users WHERE :name = first_name OR :name = last_name",
+			opts:    []Option{WithExpandRepeatedParams(true)},
+		},
+		{
+			name:    "repeated positional",
+			content: "SELECT * FROM users WHERE $1 = first_name OR $1 = last_name",
+		},
+		{
+			name:     "unknown metadata",
+			content:  "-- Description: list users\n-- Timeout: 5s\n-- cache-ttl: 1m\nSELECT * FROM users",
+			expected: []string{"unknown metadata key 'cache-ttl'"},
+		},
+		{
+			name:    "registered metadata",
+			content: "-- cache-ttl: 1m\nSELECT * FROM users",
+			opts:    []Option{WithMetadataKeys("Cache-TTL")},
+		},
+		{
+			name:     "no statements",
+			content:  "-- not written yet\n;",
+			expected: []string{"query has no statements"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			s := NewQueryStore(tc.opts...)
+			if err := s.LoadFromString("users.sql", "-- name: q\n"+tc.content+"\n"); err != nil {
+				t.Fatalf("LoadFromString: %v", err)
+			}
+
+			var messages []string
+			for _, issue := range s.Lint() {
+				if issue.Query != "q" || issue.Path != "users.sql" {
+					t.Errorf("issue for %s/%s", issue.Path, issue.Query)
+				}
+				messages = append(messages, issue.Message)
+			}
+			if !reflect.DeepEqual(messages, tc.expected) {
+				t.Errorf("Lint: got %q, expected %q", messages, tc.expected)
+			}
+		})
+	}
+}
+
+func TestSuspiciousNoParamQueries(t *testing.T) {
+	s := NewQueryStore()
+	s.insert("documented", mustNewQuery(t, "documented", "-- WHERE id = :id\nSELECT * FROM users"))
+	s.insert("with-param", mustNewQuery(t, "with-param", "-- lookup by :id\nSELECT * FROM users WHERE id = :id"))
+	s.insert("plain", mustNewQuery(t, "plain", "-- all users\nSELECT * FROM users"))
+	s.insert("time-format", mustNewQuery(t, "time-format", "SELECT to_char(now(), 'HH24:MI:SS')"))
+
+	if names := s.SuspiciousNoParamQueries(); !reflect.DeepEqual(names, []string{"documented"}) {
+		t.Errorf("SuspiciousNoParamQueries: got %v", names)
+	}
+}
+
+func TestWarnings(t *testing.T) {
+	dir := t.TempDir()
+	path := writeSQLFile(t, dir, "users.sql", "-- name: list-users\n-- WHERE id = :id\nSELECT * FROM users\n\n-- name: page-users\nSELECT * FROM users LIMIT :n\n")
+
+	s := NewQueryStore()
+	if err := s.LoadFromFile(path); err != nil {
+		t.Fatalf("LoadFromFile: %v", err)
+	}
+
+	warnings := s.Warnings()
+	if len(warnings) != 2 {
+		t.Fatalf("got %d warnings, expected 2: %v", len(warnings), warnings)
+	}
+	if w := warnings[0]; w.Query != "list-users" || w.Path != path || w.Severity != SeverityWarning {
+		t.Errorf("unexpected warning: %+v", w)
+	}
+	if w := warnings[1]; w.Query != "page-users" || w.Severity != SeverityInfo {
+		t.Errorf("unexpected warning: %+v", w)
+	}
+
+	strict := NewQueryStore(WithStrictWarnings(true))
+	if err := strict.LoadFromFile(path); err == nil {
+		t.Errorf("expected strict mode to fail the load")
+	}
+	if strict.Len() != 0 {
+		t.Errorf("strict mode loaded %d queries", strict.Len())
+	}
+}
+
+func TestScanWarnings(t *testing.T) {
+	content := "-- name: get-user\n\n-- name: list-users\nSELECT * FROM users\n"
+
+	s := NewQueryStore()
+	if err := s.LoadFromString("users.sql", content); err != nil {
+		t.Fatalf("LoadFromString: %v", err)
+	}
+
+	expected := []Warning{{
+		Query:    "get-user",
+		Path:     "users.sql",
+		Message:  "line 1: query 'get-user' has no body",
+		Severity: SeverityWarning,
+	}}
+	if warnings := s.Warnings(); !reflect.DeepEqual(warnings, expected) {
+		t.Errorf("Warnings: got %v, expected %v", warnings, expected)
+	}
+
+	strict := NewQueryStore(WithStrictWarnings(true))
+	if err := strict.LoadFromString("users.sql", content); err == nil || !strings.Contains(err.Error(), "has no body") {
+		t.Errorf("LoadFromString in strict mode: got %v, expected no body error", err)
+	}
+}
+
+func TestWithStrictScan(t *testing.T) {
+	content := "-- name: get-user\n-- nam: fetch-user\nSELECT * FROM users WHERE id = :id\n"
+
+	if warnings := loadWarningsOf(t, NewQueryStore(), content); len(warnings) != 0 {
+		t.Errorf("Warnings without WithStrictScan: %v", warnings)
+	}
+
+	expected := []Warning{{
+		Query:    "get-user",
+		Path:     "users.sql",
+		Message:  "line 2: unknown directive 'nam', did you mean 'name'?",
+		Severity: SeverityWarning,
+	}}
+	if warnings := loadWarningsOf(t, NewQueryStore(WithStrictScan(true)), content); !reflect.DeepEqual(warnings, expected) {
+		t.Errorf("Warnings: got %v, expected %v", warnings, expected)
+	}
+}
+
+func loadWarningsOf(t *testing.T, s *QueryStore, content string) []Warning {
+	t.Helper()
+
+	if err := s.LoadFromString("users.sql", content); err != nil {
+		t.Fatalf("LoadFromString: %v", err)
+	}
+	return s.Warnings()
+}
+
+func TestCommentedParamsReservedNames(t *testing.T) {
+	content := "-- name: current-tenant\nSELECT current_setting('app.tenant') = :tenant\n"
+
+	s := NewQueryStore(WithReservedNames("tenant"))
+	if warnings := loadWarningsOf(t, s, content); len(warnings) != 0 {
+		t.Errorf("Warnings: got %v for a reserved name", warnings)
+	}
+	if names := s.SuspiciousNoParamQueries(); len(names) != 0 {
+		t.Errorf("SuspiciousNoParamQueries: got %v for a reserved name", names)
+	}
+}
+
+func TestCommentedParamsOutsideComments(t *testing.T) {
+	content := "-- name: json-literal\nSELECT $$ SELECT '{\"a\":\"b\"}'::json $$\n\n" +
+		"-- name: commented\n-- WHERE id = :id\nSELECT $$ :not_a_param $$\n"
+
+	s := NewQueryStore()
+	warnings := loadWarningsOf(t, s, content)
+	if len(warnings) != 1 || warnings[0].Query != "commented" {
+		t.Errorf("Warnings: got %v, expected one for commented", warnings)
+	}
+	if names := s.SuspiciousNoParamQueries(); !reflect.DeepEqual(names, []string{"commented"}) {
+		t.Errorf("SuspiciousNoParamQueries: got %v", names)
+	}
+
+	strict := NewQueryStore(WithStrictWarnings(true))
+	if err := strict.LoadFromString("users.sql", "-- name: json-literal\nSELECT $$ SELECT '{\"a\":\"b\"}'::json $$\n"); err != nil {
+		t.Errorf("LoadFromString in strict mode: %v", err)
+	}
+}
@@ -0,0 +1,81 @@
+package queries
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// GetMetadataDuration parses the metadata value for the key with
+// time.ParseDuration, e.g. "timeout: 50ms". The bool reports whether the key
+// exists.
+func (q *Query) GetMetadataDuration(key string) (time.Duration, bool, error) {
+	value, ok := q.GetMetadata(key)
+	if !ok {
+		return 0, false, nil
+	}
+
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, true, q.metadataError(key, err)
+	}
+
+	return d, true, nil
+}
+
+// GetMetadataInt parses the metadata value for the key as an int, e.g.
+// "max-cost: 100". The bool reports whether the key exists.
+func (q *Query) GetMetadataInt(key string) (int, bool, error) {
+	value, ok := q.GetMetadata(key)
+	if !ok {
+		return 0, false, nil
+	}
+
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, true, q.metadataError(key, err)
+	}
+
+	return n, true, nil
+}
+
+// GetMetadataBool parses the metadata value for the key with
+// strconv.ParseBool, e.g. "cacheable: true". The bool reports whether the
+// key exists.
+func (q *Query) GetMetadataBool(key string) (bool, bool, error) {
+	value, ok := q.GetMetadata(key)
+	if !ok {
+		return false, false, nil
+	}
+
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, true, q.metadataError(key, err)
+	}
+
+	return b, true, nil
+}
+
+// GetMetadataList splits the metadata value for the key on commas, e.g.
+// "tags: user, authentication". Elements are trimmed and empty ones
+// dropped. The bool reports whether the key exists.
+func (q *Query) GetMetadataList(key string) ([]string, bool) {
+	value, ok := q.GetMetadata(key)
+	if !ok {
+		return nil, false
+	}
+
+	var list []string
+	for _, element := range strings.Split(value, ",") {
+		if element = strings.TrimSpace(element); element != "" {
+			list = append(list, element)
+		}
+	}
+
+	return list, true
+}
+
+func (q *Query) metadataError(key string, err error) error {
+	return fmt.Errorf("Query '%s': invalid metadata '%s': %w", q.Name, normalizeMetadataKey(key), err)
+}
@@ -0,0 +1,123 @@
+package queries
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+// metadataQuery loads a query with the given metadata lines
+func metadataQuery(t *testing.T, metadata string) *Query {
+	t.Helper()
+
+	s := NewQueryStore()
+	if err := s.LoadFromString("q.sql", "-- name: q\n"+metadata+"SELECT 1\n"); err != nil {
+		t.Fatal(err)
+	}
+
+	return s.MustHaveQuery("q")
+}
+
+func TestGetMetadataDuration(t *testing.T) {
+	testCases := []struct {
+		name          string
+		metadata      string
+		expected      time.Duration
+		expectedOK    bool
+		expectedError bool
+	}{
+		{name: "milliseconds", metadata: "-- timeout: 50ms\n", expected: 50 * time.Millisecond, expectedOK: true},
+		{name: "seconds", metadata: "-- Timeout: 2s\n", expected: 2 * time.Second, expectedOK: true},
+		{name: "missing", metadata: "", expectedOK: false},
+		{name: "invalid", metadata: "-- timeout: fast\n", expectedOK: true, expectedError: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			d, ok, err := metadataQuery(t, tc.metadata).GetMetadataDuration("timeout")
+			if (err != nil) != tc.expectedError {
+				t.Fatalf("GetMetadataDuration: got error %v, expected error %v", err, tc.expectedError)
+			}
+			if d != tc.expected || ok != tc.expectedOK {
+				t.Errorf("GetMetadataDuration: got %v, %v, expected %v, %v", d, ok, tc.expected, tc.expectedOK)
+			}
+		})
+	}
+}
+
+func TestGetMetadataInt(t *testing.T) {
+	testCases := []struct {
+		name          string
+		metadata      string
+		expected      int
+		expectedOK    bool
+		expectedError bool
+	}{
+		{name: "number", metadata: "-- max-cost: 100\n", expected: 100, expectedOK: true},
+		{name: "missing", metadata: "", expectedOK: false},
+		{name: "garbage", metadata: "-- max-cost: lots\n", expectedOK: true, expectedError: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			n, ok, err := metadataQuery(t, tc.metadata).GetMetadataInt("max-cost")
+			if (err != nil) != tc.expectedError {
+				t.Fatalf("GetMetadataInt: got error %v, expected error %v", err, tc.expectedError)
+			}
+			if n != tc.expected || ok != tc.expectedOK {
+				t.Errorf("GetMetadataInt: got %v, %v, expected %v, %v", n, ok, tc.expected, tc.expectedOK)
+			}
+		})
+	}
+}
+
+func TestGetMetadataBool(t *testing.T) {
+	testCases := []struct {
+		name          string
+		metadata      string
+		expected      bool
+		expectedOK    bool
+		expectedError bool
+	}{
+		{name: "true", metadata: "-- cacheable: true\n", expected: true, expectedOK: true},
+		{name: "false", metadata: "-- cacheable: false\n", expected: false, expectedOK: true},
+		{name: "missing", metadata: "", expectedOK: false},
+		{name: "garbage", metadata: "-- cacheable: sometimes\n", expectedOK: true, expectedError: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			b, ok, err := metadataQuery(t, tc.metadata).GetMetadataBool("cacheable")
+			if (err != nil) != tc.expectedError {
+				t.Fatalf("GetMetadataBool: got error %v, expected error %v", err, tc.expectedError)
+			}
+			if b != tc.expected || ok != tc.expectedOK {
+				t.Errorf("GetMetadataBool: got %v, %v, expected %v, %v", b, ok, tc.expected, tc.expectedOK)
+			}
+		})
+	}
+}
+
+func TestGetMetadataList(t *testing.T) {
+	testCases := []struct {
+		name       string
+		metadata   string
+		key        string
+		expected   []string
+		expectedOK bool
+	}{
+		{name: "tags", metadata: "-- tags: user, authentication, security\n", key: "tags", expected: []string{"user", "authentication", "security"}, expectedOK: true},
+		{name: "single value", metadata: "-- required-nodes: Index Scan\n", key: "required-nodes", expected: []string{"Index Scan"}, expectedOK: true},
+		{name: "empty elements", metadata: "-- tags: user,, ,security,\n", key: "tags", expected: []string{"user", "security"}, expectedOK: true},
+		{name: "missing", metadata: "", key: "tags", expectedOK: false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			list, ok := metadataQuery(t, tc.metadata).GetMetadataList(tc.key)
+			if !reflect.DeepEqual(list, tc.expected) || ok != tc.expectedOK {
+				t.Errorf("GetMetadataList: got %q, %v, expected %q, %v", list, ok, tc.expected, tc.expectedOK)
+			}
+		})
+	}
+}
@@ -0,0 +1,322 @@
+package queries
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// Option configures a QueryStore
+type Option func(*QueryStore)
+
+// DuplicatePolicy decides what happens when a loaded query has the name of
+// a query already in the store
+type DuplicatePolicy int
+
+const (
+	// PolicyError fails the load
+	PolicyError DuplicatePolicy = iota
+	// PolicySkip keeps the query loaded first
+	PolicySkip
+	// PolicyReplace keeps the query loaded last
+	PolicyReplace
+)
+
+// DefaultMaxIncludeDepth is the include nesting allowed unless changed with
+// WithMaxIncludeDepth
+const DefaultMaxIncludeDepth = 32
+
+// options holds the settings applied while loading and parsing queries
+type options struct {
+	paramsInHeader bool
+	minQueries     int
+
+	expandRepeatedParams bool
+	questionMarkParams   bool
+	atSignParams         bool
+	strictWarnings       bool
+
+	headerOnlyDirectives bool
+	strictScan           bool
+
+	syntaxValidator func(sql string) error
+	preprocessor    func(path, sql string) (string, error)
+
+	envExpansion bool
+	envVars      map[string]string
+
+	maxIncludeDepth int
+	parallelism     int
+
+	arrayBinder   func(interface{}) interface{}
+	dialect       Dialect
+	reservedNames []string
+	ignore        []string
+
+	duplicatePolicy DuplicatePolicy
+	stripComments   bool
+	strictParams    bool
+	metadataKeys    []string
+
+	namespaceFromDir   bool
+	namespaceSeparator string
+
+	watchErrorHandler func(path string, err error)
+}
+
+// WithParamsInHeader lists the query parameters in the header comment of
+// OrdinalQuery, e.g. "-- name: get-user (user_id, status)". By default only
+// the name is emitted.
+func WithParamsInHeader(enabled bool) Option {
+	return func(s *QueryStore) {
+		s.opts.paramsInHeader = enabled
+	}
+}
+
+// WithMinQueries makes every Load* method fail when it loads fewer than n
+// queries. This turns a mistyped path or embed directive into an error
+// instead of an empty store.
+func WithMinQueries(n int) Option {
+	return func(s *QueryStore) {
+		s.opts.minQueries = n
+	}
+}
+
+// WithStrictScan reports comment lines that look like mistyped directives,
+// e.g. "-- name get-user" or "-- nam: get-user", as warnings. Combined with
+// WithStrictWarnings they fail the load.
+func WithStrictScan(enabled bool) Option {
+	return func(s *QueryStore) {
+		s.opts.strictScan = enabled
+	}
+}
+
+// WithSyntaxValidator checks every query body with fn while loading. A
+// returned error aborts the load. This allows plugging in a real SQL parser
+// without the package depending on one.
+func WithSyntaxValidator(fn func(sql string) error) Option {
+	return func(s *QueryStore) {
+		s.opts.syntaxValidator = fn
+	}
+}
+
+// WithHeaderOnlyDirectives recognizes "-- name:" and the other directives
+// only in the header of a query, before its first SQL line, as is always
+// the case for metadata. Comment lines in the body are kept as plain SQL
+// until the statement ends with ";" or a blank line.
+func WithHeaderOnlyDirectives(enabled bool) Option {
+	return func(s *QueryStore) {
+		s.opts.headerOnlyDirectives = enabled
+	}
+}
+
+// WithExpandRepeatedParams gives every occurrence of a named parameter its
+// own ordinal marker, so ":id = :id" becomes "$1 = $2" and Prepare returns
+// the value twice. This matches code ported from "?" placeholders. By
+// default repeated parameters share a single ordinal.
+func WithExpandRepeatedParams(enabled bool) Option {
+	return func(s *QueryStore) {
+		s.opts.expandRepeatedParams = enabled
+	}
+}
+
+// WithPreprocessor rewrites the body of every query before it is parsed,
+// e.g. to expand macros or schema prefixes. fn receives the path of the
+// source file and the scanned SQL; a returned error aborts the load.
+func WithPreprocessor(fn func(path, sql string) (string, error)) Option {
+	return func(s *QueryStore) {
+		s.opts.preprocessor = fn
+	}
+}
+
+// WithEnvExpansion replaces "${NAME}" placeholders in query bodies while
+// loading, e.g. "SELECT * FROM ${SCHEMA}.users", before parameters are
+// detected. Values come from vars, or from the environment when vars is
+// nil. Only the braced form is expanded, so "$1" parameters and "$$"
+// quotes are left alone. An undefined variable fails the load.
+func WithEnvExpansion(vars map[string]string) Option {
+	return func(s *QueryStore) {
+		s.opts.envExpansion = true
+		s.opts.envVars = vars
+	}
+}
+
+// WithMaxIncludeDepth caps how deeply query includes may nest. Resolving a
+// deeper chain fails with an error listing the chain. Defaults to
+// DefaultMaxIncludeDepth.
+func WithMaxIncludeDepth(n int) Option {
+	return func(s *QueryStore) {
+		s.opts.maxIncludeDepth = n
+	}
+}
+
+// WithParallelism makes LoadFromDir and LoadFromDirContext parse up to n
+// files at once. The queries are still added in directory order, so the
+// result and the reported errors are the same as when loading one file
+// after the other. The preprocessor and syntax validator must be safe for
+// concurrent use.
+func WithParallelism(n int) Option {
+	return func(s *QueryStore) {
+		s.opts.parallelism = n
+	}
+}
+
+// WithQuestionMarkParams detects MySQL style "?" placeholders. They are
+// numbered from left to right and exposed as arg1..argN, like $N
+// parameters. Off by default since "?" is also a PostgreSQL jsonb operator.
+func WithQuestionMarkParams(enabled bool) Option {
+	return func(s *QueryStore) {
+		s.opts.questionMarkParams = enabled
+	}
+}
+
+// WithAtSignParams detects "@name" parameters as used by SQL Server and
+// some ORMs. They are handled like ":name" parameters, a query can't use
+// both. MySQL variables are left alone: "@@" system variables and user
+// variables assigned in the query, e.g. "SET @x = 1". Off by default since
+// "@" is also a PostgreSQL operator.
+func WithAtSignParams(enabled bool) Option {
+	return func(s *QueryStore) {
+		s.opts.atSignParams = enabled
+	}
+}
+
+// WithArrayBinder wraps slice arguments returned by Prepare with fn, e.g.
+// pq.Array for lib/pq. A slice is always bound to a single placeholder, as
+// in "WHERE id = ANY(:ids)". Without a binder slices are passed to the
+// driver as-is, which works for drivers with native array support (pgx).
+func WithArrayBinder(fn func(interface{}) interface{}) Option {
+	return func(s *QueryStore) {
+		s.opts.arrayBinder = fn
+	}
+}
+
+// WithStrictWarnings turns load warnings of SeverityWarning into errors,
+// e.g. for CI. Otherwise they are only recorded in Warnings.
+func WithStrictWarnings(enabled bool) Option {
+	return func(s *QueryStore) {
+		s.opts.strictWarnings = enabled
+	}
+}
+
+// WithDialect renders the OrdinalQuery of loaded queries with the dialect's
+// placeholders, and Prepare returns the arguments in the matching order.
+// Defaults to DialectPostgres.
+func WithDialect(d Dialect) Option {
+	return func(s *QueryStore) {
+		s.opts.dialect = d
+	}
+}
+
+// WithReservedNames replaces DefaultReservedNames, the names never detected
+// as :name parameters. Matching is case sensitive.
+func WithReservedNames(names ...string) Option {
+	return func(s *QueryStore) {
+		s.opts.reservedNames = append([]string{}, names...)
+	}
+}
+
+// reserved returns the names skipped during parameter detection
+func (o options) reserved() []string {
+	if o.reservedNames != nil {
+		return o.reservedNames
+	}
+
+	return DefaultReservedNames
+}
+
+// WithIgnore skips the files whose base name matches one of the
+// filepath.Match patterns, e.g. "*.down.sql" or "*_test.sql", in
+// LoadFromDir, LoadFromFS, LoadFromGlob and Watch. Malformed patterns match
+// nothing.
+func WithIgnore(patterns ...string) Option {
+	return func(s *QueryStore) {
+		s.opts.ignore = append(s.opts.ignore, patterns...)
+	}
+}
+
+// sqlFile reports whether the directory and glob loaders load the file
+func (o options) sqlFile(path string) bool {
+	if !strings.HasSuffix(strings.ToLower(path), ".sql") {
+		return false
+	}
+
+	base := filepath.Base(path)
+	for _, pattern := range o.ignore {
+		if ok, _ := filepath.Match(pattern, base); ok {
+			return false
+		}
+	}
+
+	return true
+}
+
+// WithDuplicatePolicy sets how loads handle names that are already in the
+// store. Defaults to PolicyError.
+func WithDuplicatePolicy(p DuplicatePolicy) Option {
+	return func(s *QueryStore) {
+		s.opts.duplicatePolicy = p
+	}
+}
+
+// WithWatchErrorHandler receives the errors hit by Watch, e.g. a changed file
+// that fails to parse. The path is empty for errors of the watcher itself.
+// By default the errors are logged.
+func WithWatchErrorHandler(fn func(path string, err error)) Option {
+	return func(s *QueryStore) {
+		s.opts.watchErrorHandler = fn
+	}
+}
+
+// WithStripComments removes the comments from OrdinalQuery and the queries
+// rendered by QueryFor. Raw keeps them.
+func WithStripComments(enabled bool) Option {
+	return func(s *QueryStore) {
+		s.opts.stripComments = enabled
+	}
+}
+
+// WithStrictParams fails the load of a query with a "-- param:" annotation
+// for a parameter the query doesn't use, usually left behind when the
+// parameter was renamed or removed. Parameters without annotation are fine.
+func WithStrictParams(enabled bool) Option {
+	return func(s *QueryStore) {
+		s.opts.strictParams = enabled
+	}
+}
+
+// WithMetadataKeys adds keys to the metadata Lint accepts. The keys used by
+// the package, e.g. "expect" and "timeout", and "description" are always
+// accepted.
+func WithMetadataKeys(keys ...string) Option {
+	return func(s *QueryStore) {
+		for _, key := range keys {
+			s.opts.metadataKeys = append(s.opts.metadataKeys, normalizeMetadataKey(key))
+		}
+	}
+}
+
+// WithNamespaceFromDir prefixes the names of queries loaded by LoadFromDir,
+// LoadFromFS and LoadFromEmbed with their directory relative to the loaded
+// root, e.g. "users/list". Aliases are prefixed as well.
+func WithNamespaceFromDir(enabled bool) Option {
+	return func(s *QueryStore) {
+		s.opts.namespaceFromDir = enabled
+	}
+}
+
+// WithNamespaceSeparator sets the separator used by WithNamespaceFromDir
+// between directories and the query name. Defaults to "/".
+func WithNamespaceSeparator(sep string) Option {
+	return func(s *QueryStore) {
+		s.opts.namespaceSeparator = sep
+	}
+}
+
+// separator returns the namespace separator
+func (o options) separator() string {
+	if o.namespaceSeparator != "" {
+		return o.namespaceSeparator
+	}
+
+	return "/"
+}
@@ -0,0 +1,92 @@
+package queries
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// parsedFile is the result of parsing a single file of a parallel load
+type parsedFile struct {
+	path      string
+	namespace string
+	queries   map[string]*Query
+	warnings  []Warning
+	err       error
+}
+
+// loadDirParallel is LoadFromDirContext parsing the files with
+// WithParallelism workers. The parsed files are added in walk order.
+func (s *QueryStore) loadDirParallel(ctx context.Context, path string) error {
+	var files []*parsedFile
+	err := filepath.Walk(path, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		if !info.IsDir() && s.opts.sqlFile(filePath) {
+			rel, _ := filepath.Rel(path, filepath.Dir(filePath))
+			files = append(files, &parsedFile{path: filePath, namespace: s.namespace(filepath.ToSlash(rel))})
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	jobs := make(chan *parsedFile)
+	var wg sync.WaitGroup
+	for i := 0; i < min(s.opts.parallelism, len(files)); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for file := range jobs {
+				s.parseFile(ctx, file)
+			}
+		}()
+	}
+	for _, file := range files {
+		jobs <- file
+	}
+	close(jobs)
+	wg.Wait()
+
+	before := s.Len()
+	for _, file := range files {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		err := file.err
+		if err == nil {
+			err = s.addParsed(file.queries, file.warnings)
+		}
+		if err != nil {
+			return fmt.Errorf("Error loading SQL file '%s': %w", file.path, err)
+		}
+	}
+	s.addSource(func(t *QueryStore) error { return t.LoadFromDir(path) })
+
+	return s.finishLoad(before)
+}
+
+func (s *QueryStore) parseFile(ctx context.Context, file *parsedFile) {
+	if file.err = ctx.Err(); file.err != nil {
+		return
+	}
+
+	f, err := os.Open(file.path)
+	if err != nil {
+		file.err = err
+		return
+	}
+	defer f.Close()
+
+	file.queries, file.warnings, file.err = s.parseQueries(file.path, file.namespace, f)
+}
@@ -0,0 +1,92 @@
+package queries
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// writeQueryTree writes n files spread over a few directories, each with a
+// couple of queries
+func writeQueryTree(t testing.TB, n int) string {
+	t.Helper()
+
+	dir := t.TempDir()
+	for i := 0; i < n; i++ {
+		path := filepath.Join(dir, fmt.Sprintf("d%d", i%4), fmt.Sprintf("f%03d.sql", i))
+		content := fmt.Sprintf("-- name: get-%d\n-- description: query %d\nSELECT * FROM t%d WHERE id = :id\n\n-- name: list-%d\n-- WHERE id = :id\nSELECT * FROM t%d LIMIT :n\n", i, i, i, i, i)
+
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return dir
+}
+
+func TestWithParallelism(t *testing.T) {
+	dir := writeQueryTree(t, 40)
+
+	for _, opts := range [][]Option{nil, {WithNamespaceFromDir(true)}} {
+		sequential := NewQueryStore(opts...)
+		if err := sequential.LoadFromDir(dir); err != nil {
+			t.Fatalf("LoadFromDir: %v", err)
+		}
+
+		parallel := NewQueryStore(append(opts, WithParallelism(8))...)
+		if err := parallel.LoadFromDir(dir); err != nil {
+			t.Fatalf("LoadFromDir in parallel: %v", err)
+		}
+
+		if !reflect.DeepEqual(parallel.Queries(), sequential.Queries()) {
+			t.Errorf("parallel load differs from sequential load")
+		}
+		if !reflect.DeepEqual(parallel.Warnings(), sequential.Warnings()) {
+			t.Errorf("Warnings: got %v, expected %v", parallel.Warnings(), sequential.Warnings())
+		}
+	}
+}
+
+func TestWithParallelismOverlappingNames(t *testing.T) {
+	dir := t.TempDir()
+	for i := 0; i < 30; i++ {
+		// every file defines "shared" and a query of its own
+		writeSQLFile(t, dir, fmt.Sprintf("f%02d.sql", i), fmt.Sprintf("-- name: shared\nSELECT %d\n\n-- name: own-%d\nSELECT %d\n", i, i, i))
+	}
+
+	for _, policy := range []DuplicatePolicy{PolicyError, PolicySkip, PolicyReplace} {
+		sequential := NewQueryStore(WithDuplicatePolicy(policy))
+		sequentialErr := sequential.LoadFromDir(dir)
+
+		for run := 0; run < 5; run++ {
+			parallel := NewQueryStore(WithDuplicatePolicy(policy), WithParallelism(6))
+			parallelErr := parallel.LoadFromDir(dir)
+
+			if fmt.Sprint(parallelErr) != fmt.Sprint(sequentialErr) {
+				t.Fatalf("policy %v: got error %v, expected %v", policy, parallelErr, sequentialErr)
+			}
+			if !reflect.DeepEqual(parallel.Queries(), sequential.Queries()) {
+				t.Fatalf("policy %v: parallel load differs from sequential load", policy)
+			}
+		}
+	}
+}
+
+func BenchmarkLoadFromDir(b *testing.B) {
+	dir := writeQueryTree(b, 200)
+
+	for _, parallelism := range []int{1, 4, 8} {
+		b.Run(fmt.Sprintf("parallelism=%d", parallelism), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if err := NewQueryStore(WithParallelism(parallelism)).LoadFromDir(dir); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,110 @@
+package queries
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+var (
+	valuerType = reflect.TypeOf((*driver.Valuer)(nil)).Elem()
+	timeType   = reflect.TypeOf(time.Time{})
+	bytesType  = reflect.TypeOf([]byte(nil))
+)
+
+// checkParamTypes returns a problem for every argument whose value doesn't
+// match the type declared in ParamTypes, sorted by parameter name
+func (q *Query) checkParamTypes(args map[string]interface{}) []string {
+	names := make([]string, 0, len(q.ParamTypes))
+	for name := range q.ParamTypes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var problems []string
+	for _, name := range names {
+		value, ok := args[name]
+		if !ok || value == nil {
+			continue
+		}
+
+		if !matchesParamType(q.ParamTypes[name], reflect.TypeOf(value)) {
+			problems = append(problems, fmt.Sprintf("argument '%s' is %T, expected %s", name, value, q.ParamTypes[name]))
+		}
+	}
+
+	return problems
+}
+
+// checkDeclaredParams fails when ParamTypes declares a parameter the query
+// doesn't use, see WithStrictParams
+func (q *Query) checkDeclaredParams() error {
+	var unused []string
+	for name := range q.ParamTypes {
+		if _, ok := q.Mapping[name]; !ok {
+			unused = append(unused, name)
+		}
+	}
+	if len(unused) == 0 {
+		return nil
+	}
+
+	sort.Strings(unused)
+	return fmt.Errorf("Query '%s' declares unused parameters: %s", q.Name, strings.Join(unused, ", "))
+}
+
+// matchesParamType reports whether a Go value of type t can be bound to a
+// parameter of the declared PostgreSQL type. driver.Valuer implementations
+// and types the check doesn't know, e.g. jsonb, always match.
+func matchesParamType(declared string, t reflect.Type) bool {
+	if t.Implements(valuerType) || reflect.PointerTo(t).Implements(valuerType) {
+		return true
+	}
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	typ := strings.ToLower(strings.TrimSpace(declared))
+	if elem, ok := strings.CutSuffix(typ, "[]"); ok {
+		if (t.Kind() != reflect.Slice && t.Kind() != reflect.Array) || t == bytesType {
+			return false
+		}
+		return matchesParamType(elem, t.Elem())
+	}
+	// drop modifiers, e.g. varchar(255) or numeric(10, 2)
+	if i := strings.IndexByte(typ, '('); i >= 0 {
+		typ = strings.TrimSpace(typ[:i])
+	}
+
+	switch typ {
+	case "int", "integer", "int2", "int4", "int8", "smallint", "bigint", "serial", "bigserial":
+		return isIntKind(t.Kind())
+	case "numeric", "decimal", "real", "float", "float4", "float8", "double precision":
+		return isIntKind(t.Kind()) || t.Kind() == reflect.Float32 || t.Kind() == reflect.Float64
+	case "text", "varchar", "char", "character", "character varying", "citext", "name":
+		return t.Kind() == reflect.String
+	case "uuid":
+		return t.Kind() == reflect.String || (t.Kind() == reflect.Array && t.Len() == 16 && t.Elem().Kind() == reflect.Uint8)
+	case "bool", "boolean":
+		return t.Kind() == reflect.Bool
+	case "date", "time", "timetz", "timestamp", "timestamptz", "timestamp with time zone", "timestamp without time zone":
+		return t == timeType
+	case "bytea":
+		return t == bytesType
+	}
+
+	return true
+}
+
+func isIntKind(k reflect.Kind) bool {
+	switch k {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return true
+	}
+
+	return false
+}
@@ -0,0 +1,101 @@
+package queries
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// planNode is a node of the PostgreSQL EXPLAIN (FORMAT JSON) output
+type planNode struct {
+	NodeType  string     `json:"Node Type"`
+	TotalCost float64    `json:"Total Cost"`
+	Plans     []planNode `json:"Plans"`
+}
+
+// ValidatePlan runs EXPLAIN (FORMAT JSON) for the query on PostgreSQL and
+// checks the plan against the metadata: the total cost must not exceed
+// "max-cost" and every node type listed in "required-nodes" must appear in
+// the plan, e.g. "required-nodes: Index Scan". All failures are reported.
+func (q *Query) ValidatePlan(ctx context.Context, db *sql.DB, args map[string]interface{}) error {
+	maxCost, hasMaxCost, err := q.GetMetadataInt("max-cost")
+	if err != nil {
+		return err
+	}
+	required, _ := q.GetMetadataList("required-nodes")
+
+	var raw []byte
+	err = db.QueryRowContext(ctx, "EXPLAIN (FORMAT JSON) "+q.SQL(), q.Prepare(args)...).Scan(&raw)
+	if err != nil {
+		return fmt.Errorf("Error explaining query '%s': %w", q.Name, err)
+	}
+
+	plan, err := parsePlan(raw)
+	if err != nil {
+		return fmt.Errorf("Error parsing plan of query '%s': %w", q.Name, err)
+	}
+
+	var errs []error
+	if hasMaxCost && plan.TotalCost > float64(maxCost) {
+		errs = append(errs, fmt.Errorf("Query '%s' plan cost %.2f exceeds max-cost %d", q.Name, plan.TotalCost, maxCost))
+	}
+
+	nodes := map[string]bool{}
+	plan.collectNodeTypes(nodes)
+	for _, node := range required {
+		if !nodes[node] {
+			errs = append(errs, fmt.Errorf("Query '%s' plan has no '%s' node", q.Name, node))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// ValidateAll runs ValidatePlan for every query annotated with "max-cost" or
+// "required-nodes", in name order, and returns the failures. Parameters are
+// bound as NULL.
+func (s *QueryStore) ValidateAll(ctx context.Context, db *sql.DB) []error {
+	s.mu.RLock()
+	var annotated []*Query
+	for _, q := range s.queriesSorted() {
+		_, hasMaxCost := q.GetMetadata("max-cost")
+		_, hasRequired := q.GetMetadata("required-nodes")
+		if hasMaxCost || hasRequired {
+			annotated = append(annotated, q)
+		}
+	}
+	s.mu.RUnlock()
+
+	var errs []error
+	for _, q := range annotated {
+		if err := q.ValidatePlan(ctx, db, nil); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errs
+}
+
+// parsePlan returns the root node of the EXPLAIN output
+func parsePlan(raw []byte) (planNode, error) {
+	var explain []struct {
+		Plan planNode `json:"Plan"`
+	}
+	if err := json.Unmarshal(raw, &explain); err != nil {
+		return planNode{}, err
+	}
+	if len(explain) == 0 {
+		return planNode{}, errors.New("empty plan")
+	}
+
+	return explain[0].Plan, nil
+}
+
+func (n planNode) collectNodeTypes(nodes map[string]bool) {
+	nodes[n.NodeType] = true
+	for _, child := range n.Plans {
+		child.collectNodeTypes(nodes)
+	}
+}
@@ -0,0 +1,137 @@
+package queries
+
+import (
+	"context"
+	"database/sql/driver"
+	"strings"
+	"testing"
+)
+
+const (
+	indexScanPlan = `[{"Plan": {"Node Type": "Limit", "Total Cost": 8.31, "Plans": [
+		{"Node Type": "Index Scan", "Total Cost": 8.29, "Index Name": "users_pkey"}
+	]}}]`
+	seqScanPlan = `[{"Plan": {"Node Type": "Seq Scan", "Total Cost": 1834.5}}]`
+)
+
+// openPlanDB returns a database answering every EXPLAIN with plan
+func openPlanDB(t *testing.T, plan string) (*fakeDB, func(*Query) error) {
+	db, fdb := openFakeDB(t, func(context.Context, string, []interface{}) (*fakeResult, error) {
+		return &fakeResult{Columns: []string{"QUERY PLAN"}, Rows: [][]driver.Value{{[]byte(plan)}}}, nil
+	})
+
+	return fdb, func(q *Query) error {
+		return q.ValidatePlan(context.Background(), db, map[string]interface{}{"id": 1})
+	}
+}
+
+func TestValidatePlan(t *testing.T) {
+	testCases := []struct {
+		name           string
+		metadata       string
+		plan           string
+		expectedErrors []string
+	}{
+		{name: "within budget", metadata: "-- max-cost: 100\n-- required-nodes: Index Scan\n", plan: indexScanPlan},
+		{name: "no annotations", metadata: "", plan: seqScanPlan},
+		{name: "too expensive", metadata: "-- max-cost: 100\n", plan: seqScanPlan, expectedErrors: []string{"plan cost 1834.50 exceeds max-cost 100"}},
+		{
+			name:           "missing nodes",
+			metadata:       "-- max-cost: 100\n-- required-nodes: Index Scan, Limit\n",
+			plan:           seqScanPlan,
+			expectedErrors: []string{"exceeds max-cost 100", "no 'Index Scan' node", "no 'Limit' node"},
+		},
+		{name: "invalid max-cost", metadata: "-- max-cost: cheap\n", plan: indexScanPlan, expectedErrors: []string{"invalid metadata 'max-cost'"}},
+		{name: "invalid plan", metadata: "", plan: "not json", expectedErrors: []string{"Error parsing plan"}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, validate := openPlanDB(t, tc.plan)
+
+			s := NewQueryStore()
+			if err := s.LoadFromString("users.sql", "-- name: get-user\n"+tc.metadata+"SELECT * FROM users WHERE id = :id LIMIT 1\n"); err != nil {
+				t.Fatal(err)
+			}
+
+			err := validate(s.MustHaveQuery("get-user"))
+			if len(tc.expectedErrors) == 0 {
+				if err != nil {
+					t.Errorf("ValidatePlan: %v", err)
+				}
+				return
+			}
+
+			if err == nil {
+				t.Fatalf("ValidatePlan: expected errors %q", tc.expectedErrors)
+			}
+			for _, expected := range tc.expectedErrors {
+				if !strings.Contains(err.Error(), expected) {
+					t.Errorf("ValidatePlan: %q does not contain %q", err.Error(), expected)
+				}
+			}
+		})
+	}
+}
+
+func TestValidatePlanExplain(t *testing.T) {
+	fdb, validate := openPlanDB(t, indexScanPlan)
+
+	if err := validate(mustNewQuery(t, "get-user", "SELECT * FROM users WHERE id = :id")); err != nil {
+		t.Fatal(err)
+	}
+
+	calls := fdb.calls()
+	if len(calls) != 1 || calls[0].Query != "EXPLAIN (FORMAT JSON) SELECT * FROM users WHERE id = $1" {
+		t.Errorf("executed %v", calls)
+	}
+}
+
+func TestValidateAll(t *testing.T) {
+	db, fdb := openFakeDB(t, func(_ context.Context, query string, _ []interface{}) (*fakeResult, error) {
+		plan := indexScanPlan
+		if strings.Contains(query, "orders") {
+			plan = seqScanPlan
+		}
+		return &fakeResult{Columns: []string{"QUERY PLAN"}, Rows: [][]driver.Value{{[]byte(plan)}}}, nil
+	})
+
+	s := NewQueryStore()
+	err := s.LoadFromString("plans.sql", `-- name: get-user
+-- max-cost: 100
+-- required-nodes: Index Scan
+SELECT * FROM users WHERE id = :id
+
+-- name: list-orders
+-- max-cost: 100
+SELECT * FROM orders WHERE user_id = :user_id
+
+-- name: scan-orders
+-- required-nodes: Index Scan
+SELECT * FROM orders
+
+-- name: count-orders
+SELECT count(*) FROM orders
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	errs := s.ValidateAll(context.Background(), db)
+	if len(errs) != 2 {
+		t.Fatalf("ValidateAll: got %d errors, expected 2: %v", len(errs), errs)
+	}
+	if !strings.Contains(errs[0].Error(), "list-orders") || !strings.Contains(errs[1].Error(), "scan-orders") {
+		t.Errorf("ValidateAll: got %v", errs)
+	}
+
+	// count-orders has no annotations and is not explained
+	for _, call := range fdb.calls() {
+		if strings.Contains(call.Query, "count(*)") {
+			t.Errorf("ValidateAll explained the unannotated query: %q", call.Query)
+		}
+	}
+	if calls := fdb.calls(); len(calls) != 3 {
+		t.Errorf("ValidateAll: executed %d statements, expected 3", len(calls))
+	}
+}
@@ -1,108 +1,486 @@
 package queries
 
 import (
-	"bufio"
+	"context"
 	"database/sql"
 	"embed"
+	"errors"
 	"fmt"
+	"hash/fnv"
 	"io"
 	"io/fs"
+	"maps"
 	"os"
+	pathpkg "path"
 	"path/filepath"
+	"reflect"
 	"regexp"
+	"slices"
 	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"text/template"
 )
 
+var (
+	// psqlVarRE matches :name, :'name' and :"name" variables. The leading
+	// [^:] keeps "::" casts out, and since neither "=>" nor ":=" (named
+	// function arguments) is followed by a letter, only the variable after
+	// them is detected. A variable may also start the query.
+	psqlVarRE = regexp.MustCompile(`(?:^|[^:]):['"]?([A-Za-z][A-Za-z0-9_]*)['"]?`)
+
+	positionalParamRE = regexp.MustCompile(`\$(\d+)`)
+
+	// envVarRE matches "${NAME}" placeholders, see WithEnvExpansion
+	envVarRE = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+	// atSignParamRE matches @name parameters. The leading [^@] keeps "@@"
+	// operators out.
+	atSignParamRE = regexp.MustCompile(`(?:^|[^@])@([A-Za-z][A-Za-z0-9_]*)`)
+	// userVarAssignRE matches the assignments of MySQL user variables:
+	// "SET @x = 1", "@x := 1" and "INTO @x"
+	userVarAssignRE = regexp.MustCompile(`(?i)(?:\bSET\s+@([A-Za-z][A-Za-z0-9_]*)\s*:?=|@([A-Za-z][A-Za-z0-9_]*)\s*:=|\bINTO\s+@([A-Za-z][A-Za-z0-9_]*))`)
+)
+
+// ParamStyle is the way parameters are written in a query
+type ParamStyle int
+
+const (
+	// StyleNone is the style of queries without parameters
+	StyleNone ParamStyle = iota
+	// StyleColon is the psql variable style, ":name"
+	StyleColon
+	// StylePositional is the PostgreSQL style, "$1"
+	StylePositional
+	// StyleAtSign is "@name", see WithAtSignParams
+	StyleAtSign
+	// StyleQuestionMark is "?", see WithQuestionMarkParams
+	StyleQuestionMark
+)
+
+func (s ParamStyle) String() string {
+	switch s {
+	case StyleNone:
+		return "none"
+	case StyleColon:
+		return "colon"
+	case StylePositional:
+		return "positional"
+	case StyleAtSign:
+		return "at sign"
+	case StyleQuestionMark:
+		return "question mark"
+	}
+
+	return fmt.Sprintf("ParamStyle(%d)", int(s))
+}
+
+// parameter styles, as reported when a query mixes them
 const (
-	psqlVarRE = `[^:]:['"]?([A-Za-z][A-Za-z0-9_]*)['"]?`
+	styleNamed        = "named (:name)"
+	stylePositional   = "positional ($1)"
+	styleQuestionMark = "question mark (?)"
+	styleAtSign       = "at sign (@name)"
 )
 
 var (
-	reservedNames = []string{"MI", "SS"}
+	// DefaultReservedNames are never detected as parameters. They cover the
+	// uppercase to_char date/time tokens, e.g. 'HH24:MI:SS'.
+	DefaultReservedNames = []string{
+		"HH", "HH12", "HH24", "MI", "SS", "MS", "US", "SSSS", "SSSSS",
+		"AM", "PM", "TZ", "TZH", "TZM", "OF",
+		"Y", "YY", "YYY", "YYYY", "IYYY", "MM", "MON", "MONTH",
+		"D", "DD", "DDD", "DY", "DAY", "WW", "IW", "Q", "CC", "J",
+	}
 )
 
 type (
 	QueryStore struct {
+		mu      sync.RWMutex
 		queries map[string]*Query
+		// aliases maps every alias to the name of its query
+		aliases  map[string]string
+		opts     options
+		warnings []Warning
+
+		// names caches the sorted query names; nil when stale. It is
+		// rebuilt by readers, so it has its own lock.
+		namesMu sync.Mutex
+		names   []string
+
+		// stmts caches the statements prepared by Prepare
+		stmtMu sync.Mutex
+		stmts  map[stmtKey]*sql.Stmt
+
+		// sources are the loads repeated by Reload
+		sources []loadSource
 	}
 
 	Query struct {
-		Name         string
+		Name string
+		// Aliases are additional names the query is found by
+		Aliases []string
+		// Path is the file the query was loaded from
+		Path         string
 		Raw          string
 		OrdinalQuery string
 		Mapping      map[string]int
-		NamedArgs    []sql.NamedArg
+		// Args lists the parameter names in order of appearance,
+		// including repeated ones
+		Args      []string
+		NamedArgs []sql.NamedArg
+		// Metadata is keyed by the lowercased key, MetadataOriginal returns
+		// the keys as authored
+		Metadata map[string]string
+		// Expect is the row count declared by the "expect" metadata
+		Expect Cardinality
+		// ParamTypes are the parameter types declared by "-- param: id int"
+		// annotations, checked by PrepareStrict
+		ParamTypes map[string]string
+		// Style is the parameter style used in Raw
+		Style ParamStyle
+
+		metadataOriginal map[string]string
+		// ordinals holds the parameter name bound to each ordinal marker
+		ordinals    []string
+		verb        string
+		arrayBinder func(interface{}) interface{}
+		// spans are the parameter occurrences in Raw
+		spans   []paramSpan
+		header  string
+		dialect Dialect
+		// stripComments removes the comments from the rendered query
+		stripComments bool
+		// template is set for queries with "template: true" metadata, with
+		// the options Render parses the output with
+		template     *template.Template
+		templateOpts options
+		// source is the body with its include directives, set only for
+		// queries including others. Raw holds the assembled text.
+		source string
+		// includeErr is set while the includes of the query can't be
+		// resolved; Query returns it instead of the query
+		includeErr error
+		// parseErr is the error NewQuery recorded for a query it could
+		// not parse
+		parseErr error
 	}
 )
 
 // NewQueryStore setups new query store
-func NewQueryStore() *QueryStore {
-	return &QueryStore{
+func NewQueryStore(opts ...Option) *QueryStore {
+	s := &QueryStore{
 		queries: make(map[string]*Query),
+		aliases: make(map[string]string),
+		opts: options{
+			maxIncludeDepth: DefaultMaxIncludeDepth,
+		},
 	}
+
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	return s
 }
 
 // LoadFromFile loads query/queries from specified file
-func (s *QueryStore) LoadFromFile(fileName string) (err error) {
+func (s *QueryStore) LoadFromFile(fileName string) error {
+	before := s.Len()
+	if err := s.loadFile(fileName, ""); err != nil {
+		return err
+	}
+	s.addSource(func(t *QueryStore) error { return t.LoadFromFile(fileName) })
+
+	return s.finishLoad(before)
+}
+
+// LoadFromFiles loads the files in order and stops at the first one that
+// fails. Queries from the files before it stay loaded.
+func (s *QueryStore) LoadFromFiles(paths ...string) error {
+	before := s.Len()
+
+	for _, path := range paths {
+		if err := s.loadFile(path, ""); err != nil {
+			return fmt.Errorf("Error loading SQL file '%s': %w", path, err)
+		}
+	}
+	s.addSource(func(t *QueryStore) error { return t.LoadFromFiles(paths...) })
+
+	return s.finishLoad(before)
+}
+
+// LoadFromReader loads queries from r. The name is used as the path of the
+// loaded queries and, without its extension, as the name of a query lacking
+// a name directive.
+func (s *QueryStore) LoadFromReader(name string, r io.Reader) error {
+	before := s.Len()
+	if err := s.loadQueriesFromFile(name, "", r); err != nil {
+		return err
+	}
+
+	return s.finishLoad(before)
+}
+
+// LoadFromString loads queries from content, see LoadFromReader
+func (s *QueryStore) LoadFromString(name, content string) error {
+	return s.LoadFromReader(name, strings.NewReader(content))
+}
+
+func (s *QueryStore) loadFile(fileName, namespace string) error {
 	file, err := os.Open(fileName)
 	if err != nil {
 		return err
 	}
 	defer file.Close()
 
-	return s.loadQueriesFromFile(fileName, file)
+	return s.loadQueriesFromFile(fileName, namespace, file)
 }
 
+// LoadFromDir loads all .sql files from path and its subdirectories
 func (s *QueryStore) LoadFromDir(path string) error {
+	return s.LoadFromDirContext(context.Background(), path)
+}
+
+// LoadFromDirContext is LoadFromDir stopping with ctx.Err() once ctx is
+// done. Files loaded before that stay loaded.
+func (s *QueryStore) LoadFromDirContext(ctx context.Context, path string) error {
 	if _, err := os.Stat(path); err != nil {
 		return fmt.Errorf("Directory does not exist: %s", path)
 	}
 
+	if s.opts.parallelism > 1 {
+		return s.loadDirParallel(ctx, path)
+	}
+
+	before := s.Len()
+
 	err := filepath.Walk(path, func(filePath string, info os.FileInfo, err error) error {
 		if err != nil {
 			return err
 		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
 
-		if !info.IsDir() && strings.HasSuffix(strings.ToLower(filePath), ".sql") {
-			err = s.LoadFromFile(filePath)
+		if !info.IsDir() && s.opts.sqlFile(filePath) {
+			rel, _ := filepath.Rel(path, filepath.Dir(filePath))
+			err = s.loadFile(filePath, s.namespace(filepath.ToSlash(rel)))
 			if err != nil {
-				return fmt.Errorf("Error loading SQL file '%s': %v", filePath, err)
+				return fmt.Errorf("Error loading SQL file '%s': %w", filePath, err)
 			}
 		}
 
 		return nil
 	})
+	if err != nil {
+		return err
+	}
+	s.addSource(func(t *QueryStore) error { return t.LoadFromDir(path) })
 
-	return err
+	return s.finishLoad(before)
 }
 
-func (qs *QueryStore) LoadFromEmbed(sqlFS embed.FS, path string) error {
-	dirEntries, err := fs.ReadDir(sqlFS, path)
+// LoadFromGlob loads the .sql files matching the filepath.Match pattern,
+// e.g. "sql/*/queries/*.sql". Other matches are skipped. A pattern without
+// matches loads nothing, see WithMinQueries.
+func (s *QueryStore) LoadFromGlob(pattern string) error {
+	paths, err := filepath.Glob(pattern)
 	if err != nil {
-		return err
+		return fmt.Errorf("Invalid pattern '%s': %w", pattern, err)
 	}
 
-	for _, entry := range dirEntries {
-		filePath := entry.Name()
+	before := s.Len()
+
+	for _, path := range paths {
+		if !s.opts.sqlFile(path) {
+			continue
+		}
+		if info, err := os.Stat(path); err != nil || info.IsDir() {
+			continue
+		}
+
+		if err := s.loadFile(path, ""); err != nil {
+			return fmt.Errorf("Error loading SQL file '%s': %w", path, err)
+		}
+	}
+	s.addSource(func(t *QueryStore) error { return t.LoadFromGlob(pattern) })
+
+	return s.finishLoad(before)
+}
+
+// LoadFromEmbed loads all .sql files from path and its subdirectories
+func (s *QueryStore) LoadFromEmbed(sqlFS embed.FS, path string) error {
+	return s.LoadFromFS(sqlFS, path)
+}
+
+// LoadFromFS loads all .sql files from root and its subdirectories of fsys,
+// e.g. os.DirFS or an embed.FS
+func (s *QueryStore) LoadFromFS(fsys fs.FS, root string) error {
+	before := s.Len()
+
+	err := fs.WalkDir(fsys, root, func(filePath string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
 
-		if !entry.IsDir() && strings.HasSuffix(strings.ToLower(filePath), ".sql") {
-			file, err := sqlFS.Open(filepath.Join(path, filePath))
+		if !entry.IsDir() && s.opts.sqlFile(filePath) {
+			file, err := fsys.Open(filePath)
 			if err != nil {
-				return fmt.Errorf("Error opening SQL file '%s': %v", filePath, err)
+				return fmt.Errorf("Error opening SQL file '%s': %w", entry.Name(), err)
 			}
 			defer file.Close()
 
-			err = qs.loadQueriesFromFile(filePath, file)
+			err = s.loadQueriesFromFile(entry.Name(), s.namespace(relativeDir(root, filePath)), file)
 			if err != nil {
-				return fmt.Errorf("Error loading SQL file '%s': %v", filePath, err)
+				return fmt.Errorf("Error loading SQL file '%s': %w", entry.Name(), err)
 			}
 		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	s.addSource(func(t *QueryStore) error { return t.LoadFromFS(fsys, root) })
+
+	return s.finishLoad(before)
+}
+
+// namespace returns the name prefix for the files in the slash separated
+// directory relative to the loaded root, see WithNamespaceFromDir
+func (s *QueryStore) namespace(rel string) string {
+	if !s.opts.namespaceFromDir || rel == "." || rel == "" {
+		return ""
+	}
+
+	return strings.ReplaceAll(rel, "/", s.opts.separator())
+}
+
+func (s *QueryStore) qualify(namespace, name string) string {
+	return namespace + s.opts.separator() + name
+}
+
+// relativeDir returns the directory of the fs.FS path relative to root
+func relativeDir(root, filePath string) string {
+	dir := pathpkg.Dir(filePath)
+	if root == "." {
+		return dir
+	}
+
+	return strings.TrimPrefix(strings.TrimPrefix(dir, root), "/")
+}
+
+// finishLoad resolves the includes once all files of a load call are in and
+// checks the number of loaded queries. before is the Len before the call.
+func (s *QueryStore) finishLoad(before int) error {
+	if err := s.resolveIncludes(); err != nil {
+		return err
+	}
+
+	return s.checkMinQueries(s.Len() - before)
+}
+
+// checkMinQueries enforces the WithMinQueries option for a single load call
+func (s *QueryStore) checkMinQueries(loaded int) error {
+	if loaded < s.opts.minQueries {
+		return fmt.Errorf("Loaded %d queries, expected at least %d", loaded, s.opts.minQueries)
 	}
 
 	return nil
 }
 
+// QueryNames returns the names of all loaded queries in sorted order
+func (s *QueryStore) QueryNames() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	names := s.sortedNames()
+
+	result := make([]string, len(names))
+	copy(result, names)
+
+	return result
+}
+
+// Queries returns copies of the loaded queries keyed by name, see Clone.
+// Changing them doesn't affect the store.
+func (s *QueryStore) Queries() map[string]*Query {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	queries := make(map[string]*Query, len(s.queries))
+	for name, q := range s.queries {
+		queries[name] = q.Clone()
+	}
+
+	return queries
+}
+
+// ForEach calls fn for every loaded query in name order until fn returns
+// false. The queries are not copied and must not be changed. The store is
+// read locked meanwhile, so fn must not load or remove queries.
+func (s *QueryStore) ForEach(fn func(name string, q *Query) bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, name := range s.sortedNames() {
+		if !fn(name, s.queries[name]) {
+			return
+		}
+	}
+}
+
+// Filter returns the queries pred reports true for, sorted by name, e.g.
+// the ones with a "max-cost" metadata key. Like ForEach it runs under the
+// read lock and the returned queries are shared with the store.
+func (s *QueryStore) Filter(pred func(*Query) bool) []*Query {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var queries []*Query
+	for _, name := range s.sortedNames() {
+		if q := s.queries[name]; pred(q) {
+			queries = append(queries, q)
+		}
+	}
+
+	return queries
+}
+
+// sortedNames returns the cached sorted names, rebuilding them when the
+// store changed. The caller must hold s.mu and not modify the result.
+func (s *QueryStore) sortedNames() []string {
+	s.namesMu.Lock()
+	defer s.namesMu.Unlock()
+
+	if s.names != nil {
+		return s.names
+	}
+
+	names := make([]string, 0, len(s.queries))
+	for name := range s.queries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	s.names = names
+	return names
+}
+
+// queriesSorted returns the loaded queries in name order. The caller must
+// hold s.mu.
+func (s *QueryStore) queriesSorted() []*Query {
+	names := s.sortedNames()
+	queries := make([]*Query, len(names))
+	for i, name := range names {
+		queries[i] = s.queries[name]
+	}
+
+	return queries
+}
+
 // MustHaveQuery returns query or panics on error
 func (s *QueryStore) MustHaveQuery(name string) *Query {
 	query, err := s.Query(name)
@@ -113,79 +491,802 @@ func (s *QueryStore) MustHaveQuery(name string) *Query {
 	return query
 }
 
+// QueryOr returns the query with the name or alias, or fallback when it
+// isn't loaded. Unlike MustHaveQuery it never panics.
+func (s *QueryStore) QueryOr(name string, fallback *Query) *Query {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if query, ok := s.lookup(name); ok && query.includeErr == nil {
+		return query
+	}
+
+	return fallback
+}
+
 // Query retrieve query by given name
 func (s *QueryStore) Query(name string) (*Query, error) {
-	query, ok := s.queries[name]
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	query, ok := s.lookup(name)
 	if !ok {
-		return nil, fmt.Errorf("Query '%s' not found", name)
+		return nil, &QueryNotFoundError{Name: name}
+	}
+	if query.includeErr != nil {
+		return nil, query.includeErr
 	}
 
 	return query, nil
 }
 
-func (s *QueryStore) loadQueriesFromFile(fileName string, r io.Reader) error {
-	scanner := &Scanner{}
-	newQueries := scanner.Run(fileName, bufio.NewScanner(r))
+// lookup finds the query by name or alias; names win over aliases. The
+// caller must hold the lock.
+func (s *QueryStore) lookup(name string) (*Query, bool) {
+	if query, ok := s.queries[name]; ok {
+		return query, true
+	}
+
+	query, ok := s.queries[s.aliases[name]]
+	return query, ok
+}
+
+// PreviewFile parses the file and returns the queries it would add without
+// inserting them into the store
+func (s *QueryStore) PreviewFile(fileName string) (map[string]*Query, error) {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	queries, _, err := s.parseQueries(fileName, "", file)
+	return queries, err
+}
+
+func (s *QueryStore) loadQueriesFromFile(fileName, namespace string, r io.Reader) error {
+	newQueries, scanWarnings, err := s.parseQueries(fileName, namespace, r)
+	if err != nil {
+		return err
+	}
+
+	return s.addParsed(newQueries, scanWarnings)
+}
+
+// addParsed inserts the queries parsed from a single file, see
+// parseQueries, with their warnings
+func (s *QueryStore) addParsed(newQueries map[string]*Query, scanWarnings []Warning) error {
+	names, warnings, err := s.checkParsed(newQueries, scanWarnings)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.insertAll(names, newQueries); err != nil {
+		return err
+	}
+
+	s.warnings = append(s.warnings, warnings...)
+
+	return nil
+}
+
+// checkParsed returns the sorted names of the queries parsed from a single
+// file and all their warnings, failing on the first one with
+// WithStrictWarnings
+func (s *QueryStore) checkParsed(newQueries map[string]*Query, scanWarnings []Warning) ([]string, []Warning, error) {
+	names := make([]string, 0, len(newQueries))
+	for name := range newQueries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	if s.opts.strictWarnings && len(scanWarnings) > 0 {
+		return nil, nil, fmt.Errorf("%s: %s", scanWarnings[0].Path, scanWarnings[0].Message)
+	}
+
+	warnings := scanWarnings
+	for _, name := range names {
+		for _, w := range loadWarnings(newQueries[name], s.opts) {
+			if s.opts.strictWarnings && w.Severity >= SeverityWarning {
+				return nil, nil, fmt.Errorf("Query '%s': %s", name, w.Message)
+			}
+			warnings = append(warnings, w)
+		}
+	}
+
+	return names, warnings, nil
+}
+
+// Merge copies the queries and warnings of other into the store, resolving
+// name collisions with the duplicate policy of the store. Under PolicyError
+// a collision fails the merge before anything is copied.
+func (s *QueryStore) Merge(other *QueryStore) error {
+	if other == s {
+		return nil
+	}
+
+	other.mu.RLock()
+	names := append([]string{}, other.sortedNames()...)
+	queries := make(map[string]*Query, len(other.queries))
+	for name, q := range other.queries {
+		queries[name] = q
+	}
+	warnings := append([]Warning{}, other.warnings...)
+	other.mu.RUnlock()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.insertAll(names, queries); err != nil {
+		return err
+	}
+
+	s.warnings = append(s.warnings, warnings...)
+
+	return s.resolveIncludesLocked()
+}
+
+// insertAll adds the queries in the order of names, applying the duplicate
+// policy. The caller must hold the write lock.
+func (s *QueryStore) insertAll(names []string, queries map[string]*Query) error {
+	// check for duplicates first so a failed load inserts nothing
+	if s.opts.duplicatePolicy == PolicyError {
+		if err := s.checkDuplicates(names, queries); err != nil {
+			return err
+		}
+	}
 
-	for name, query := range newQueries {
-		// insert query (but check whatever it already exists)
+	for _, name := range names {
+		if _, ok := s.queries[name]; ok && s.opts.duplicatePolicy == PolicySkip {
+			continue
+		}
+
+		s.insert(name, queries[name])
+	}
+
+	return nil
+}
+
+// checkDuplicates reports the first name or alias of the queries that is
+// already taken in the store or among the queries themselves. The caller
+// must hold the lock.
+func (s *QueryStore) checkDuplicates(names []string, queries map[string]*Query) error {
+	for _, name := range names {
 		if _, ok := s.queries[name]; ok {
 			return fmt.Errorf("Query '%s' already exists", name)
 		}
+		if owner, ok := s.aliases[name]; ok {
+			return fmt.Errorf("Query '%s' collides with an alias of query '%s'", name, owner)
+		}
+	}
 
-		q := NewQuery(name, query)
-
-		s.queries[name] = q
+	owners := make(map[string]string)
+	for _, name := range names {
+		for _, alias := range queries[name].Aliases {
+			if _, ok := s.lookup(alias); ok {
+				return fmt.Errorf("Alias '%s' of query '%s' is already taken", alias, name)
+			}
+			if _, ok := queries[alias]; ok {
+				return fmt.Errorf("Alias '%s' of query '%s' collides with a query name", alias, name)
+			}
+			if owner, ok := owners[alias]; ok {
+				return fmt.Errorf("Alias '%s' of query '%s' is also an alias of query '%s'", alias, name, owner)
+			}
+			owners[alias] = name
+		}
 	}
 
 	return nil
 }
 
+// insert adds the query and invalidates the cached names. The caller must
+// hold the write lock.
+func (s *QueryStore) insert(name string, q *Query) {
+	if _, ok := s.queries[name]; ok {
+		s.delete(name)
+	}
+
+	s.queries[name] = q
+	for _, alias := range q.Aliases {
+		s.aliases[alias] = name
+	}
+	s.invalidateNames()
+}
+
+// delete removes the query, its aliases and its cached statements. The
+// caller must hold the write lock.
+func (s *QueryStore) delete(name string) {
+	for _, alias := range s.queries[name].Aliases {
+		if s.aliases[alias] == name {
+			delete(s.aliases, alias)
+		}
+	}
+
+	delete(s.queries, name)
+	s.closeStmts(name)
+}
+
+func (s *QueryStore) invalidateNames() {
+	s.namesMu.Lock()
+	s.names = nil
+	s.namesMu.Unlock()
+}
+
+// Remove deletes the query and reports whether it was loaded
+func (s *QueryStore) Remove(name string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.queries[name]; !ok {
+		return false
+	}
+
+	s.delete(name)
+	s.invalidateNames()
+
+	return true
+}
+
+// QueriesByPath returns the queries loaded from path, sorted by name. The
+// path must match Query.Path as set by the loader: the full path for
+// LoadFromFile and LoadFromDir, the base name for LoadFromFS and
+// LoadFromEmbed.
+func (s *QueryStore) QueriesByPath(path string) []*Query {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var queries []*Query
+	for _, name := range s.sortedNames() {
+		if q := s.queries[name]; q.Path == path {
+			queries = append(queries, q)
+		}
+	}
+
+	return queries
+}
+
+// RemoveByPath deletes the queries loaded from path, see QueriesByPath, and
+// returns how many were removed
+func (s *QueryStore) RemoveByPath(path string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	removed := 0
+	for name, q := range s.queries {
+		if q.Path == path {
+			s.delete(name)
+			removed++
+		}
+	}
+
+	if removed > 0 {
+		s.invalidateNames()
+	}
+
+	return removed
+}
+
+// Clear removes all queries, their cached statements, recorded warnings
+// and the sources remembered for Reload
+func (s *QueryStore) Clear() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.Close()
+
+	s.queries = make(map[string]*Query)
+	s.aliases = make(map[string]string)
+	s.warnings = nil
+	s.sources = nil
+	s.invalidateNames()
+}
+
+// Has reports whether a query with the name is loaded
+func (s *QueryStore) Has(name string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	_, ok := s.lookup(name)
+	return ok
+}
+
+// Len returns the number of loaded queries
+func (s *QueryStore) Len() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return len(s.queries)
+}
+
+// parseQueries parses the queries of the file, prefixing their names and
+// aliases with the namespace if set. The problems reported by the scanner
+// are returned as warnings.
+func (s *QueryStore) parseQueries(fileName, namespace string, r io.Reader) (map[string]*Query, []Warning, error) {
+	scanner := &Scanner{HeaderOnly: s.opts.headerOnlyDirectives, Strict: s.opts.strictScan}
+	lines := NewLineScanner(r)
+	scanned := scanner.ScanQueries(fileName, lines)
+	if err := lines.Err(); err != nil {
+		return nil, nil, fmt.Errorf("%s:%d: %w", fileName, scanner.lineNo+1, err)
+	}
+
+	var warnings []Warning
+	for _, err := range scanner.Errors {
+		var scanErr *ScanError
+		if errors.As(err, &scanErr) {
+			warnings = append(warnings, Warning{
+				Query:    scanErr.Query,
+				Path:     scanErr.Path,
+				Message:  fmt.Sprintf("line %d: %s", scanErr.Line, scanErr.Message),
+				Severity: SeverityWarning,
+			})
+		}
+	}
+
+	// parse in file order so the first broken query is reported
+	names := make([]string, 0, len(scanned))
+	for name := range scanned {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		return scanned[names[i]].Line < scanned[names[j]].Line
+	})
+
+	queries := make(map[string]*Query, len(scanned))
+	for _, name := range names {
+		sq := scanned[name]
+
+		if namespace != "" {
+			name = s.qualify(namespace, name)
+			for i, alias := range sq.Aliases {
+				sq.Aliases[i] = s.qualify(namespace, alias)
+			}
+		}
+
+		q, err := s.parseQuery(fileName, name, sq)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s:%d: %w", fileName, sq.Line, err)
+		}
+
+		queries[name] = q
+	}
+
+	return queries, warnings, nil
+}
+
+// parseQuery builds the query from a single scanned body
+func (s *QueryStore) parseQuery(fileName, name string, sq *ScannedQuery) (*Query, error) {
+	if s.opts.envExpansion {
+		body, err := expandEnv(sq.Query, s.opts.envVars)
+		if err != nil {
+			return nil, fmt.Errorf("Query '%s': %v", name, err)
+		}
+		sq.Query = body
+	}
+
+	if s.opts.preprocessor != nil {
+		body, err := s.opts.preprocessor(fileName, sq.Query)
+		if err != nil {
+			return nil, fmt.Errorf("Error preprocessing query '%s': %v", name, err)
+		}
+		sq.Query = body
+	}
+
+	if s.opts.syntaxValidator != nil {
+		if err := s.opts.syntaxValidator(sq.Query); err != nil {
+			return nil, fmt.Errorf("Query '%s' is not valid: %v", name, err)
+		}
+	}
+
+	q, err := newQuery(name, sq.Query, s.opts)
+	if err != nil {
+		return nil, err
+	}
+	q.Path = fileName
+	q.Aliases = sq.Aliases
+	q.ParamTypes = sq.ParamTypes
+	if includeTagRE.MatchString(sq.Query) {
+		q.source = sq.Query
+	}
+
+	if err := q.setMetadata(sq.Metadata, sq.MetadataOriginal, s.opts); err != nil {
+		return nil, err
+	}
+
+	// the parameters of included queries are only known once resolved
+	if s.opts.strictParams && q.source == "" {
+		if err := q.checkDeclaredParams(); err != nil {
+			return nil, err
+		}
+	}
+
+	return q, nil
+}
+
+// setMetadata sets the metadata of the query and applies the keys changing
+// how it is run: "expect" and "template"
+func (q *Query) setMetadata(metadata, original map[string]string, opts options) error {
+	q.Metadata = metadata
+	q.metadataOriginal = original
+
+	if value, ok := q.GetMetadata("expect"); ok {
+		expect, err := parseCardinality(value)
+		if err != nil {
+			return fmt.Errorf("Query '%s': %v", q.Name, err)
+		}
+		q.Expect = expect
+	}
+
+	return q.parseTemplate(opts)
+}
+
+// expandEnv replaces the ${NAME} placeholders of the query with values
+// from vars, or from the environment when vars is nil
+func expandEnv(query string, vars map[string]string) (string, error) {
+	lookup := os.LookupEnv
+	if vars != nil {
+		lookup = func(name string) (string, bool) {
+			value, ok := vars[name]
+			return value, ok
+		}
+	}
+
+	var undefined []string
+	expanded := envVarRE.ReplaceAllStringFunc(query, func(placeholder string) string {
+		name := placeholder[2 : len(placeholder)-1]
+		value, ok := lookup(name)
+		if !ok {
+			if !slices.Contains(undefined, name) {
+				undefined = append(undefined, name)
+			}
+			return placeholder
+		}
+		return value
+	})
+
+	if len(undefined) > 0 {
+		return "", fmt.Errorf("undefined variables: %s", strings.Join(undefined, ", "))
+	}
+
+	return expanded, nil
+}
+
+// NewQuery parses the query and maps its parameters to ordinal markers.
+// A query mixing parameter styles is returned unmapped with the error
+// recorded, see Err; use ParseQuery to get the error directly.
 func NewQuery(name, query string) *Query {
-	var (
-		position int = 1
-	)
+	q, err := ParseQuery(name, query)
+	if err != nil {
+		return &Query{Name: name, Raw: query, OrdinalQuery: query, parseErr: err}
+	}
+
+	return q
+}
 
+// ParseQuery is NewQuery returning an error, a *MixedParameterStyleError,
+// when the query mixes parameter styles
+func ParseQuery(name, query string) (*Query, error) {
+	return newQuery(name, query, options{})
+}
+
+// MustParseQuery is ParseQuery panicking on error
+func MustParseQuery(name, query string) *Query {
+	q, err := ParseQuery(name, query)
+	if err != nil {
+		panic(err)
+	}
+
+	return q
+}
+
+func newQuery(name, query string, opts options) (*Query, error) {
 	q := Query{
-		Name: name,
-		Raw:  query,
+		Name:        name,
+		Raw:         query,
+		verb:        statementVerb(query),
+		arrayBinder: opts.arrayBinder,
 	}
 
-	// TODO: should drop
-	mapping := make(map[string]int)
-	namedArgs := []sql.NamedArg{}
+	// detect on copies without comments and dollar-quoted bodies (and
+	// literals for the other styles); offsets stay the same as in the query
+	stripped := stripSQLComments(query)
+	masked := maskLiterals(stripped)
+
+	named := findNamedParams(maskDollarQuoted(stripped), opts.reserved())
+	positional := findPositionalParams(masked)
+
+	var questionMarks, atSigns []paramSpan
+	if opts.questionMarkParams {
+		questionMarks = findQuestionMarkParams(masked)
+	}
+	if opts.atSignParams {
+		atSigns = findAtSignParams(masked)
+	}
+
+	err := validateSingleParameterStyle(name, map[string]int{
+		styleNamed:        len(named),
+		stylePositional:   len(positional),
+		styleQuestionMark: len(questionMarks),
+		styleAtSign:       len(atSigns),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case len(positional) > 0:
+		q.Style = StylePositional
+		query = q.handlePositionalParams(query, positional)
+	case len(questionMarks) > 0:
+		q.Style = StyleQuestionMark
+		query = q.handleQuestionMarkParams(query, questionMarks)
+	case len(atSigns) > 0:
+		q.Style = StyleAtSign
+		query = q.handleNamedParams(query, atSigns, opts)
+	default:
+		if len(named) > 0 {
+			q.Style = StyleColon
+		}
+		query = q.handleNamedParams(query, named, opts)
+	}
+
+	if opts.stripComments {
+		q.stripComments = true
+		query = removeSQLComments(query)
+	}
+
+	q.header = name
+	if opts.paramsInHeader && len(q.NamedArgs) > 0 {
+		params := make([]string, len(q.NamedArgs))
+		for i, arg := range q.NamedArgs {
+			params[i] = arg.Name
+		}
+		q.header = fmt.Sprintf("%s (%s)", name, strings.Join(params, ", "))
+	}
+
+	q.OrdinalQuery = fmt.Sprintf("-- name: %s\n%s", q.header, query)
+	if opts.dialect.Placeholder != nil {
+		q.dialect = opts.dialect
+		q.OrdinalQuery = q.QueryFor(opts.dialect)
+	}
+
+	return &q, nil
+}
 
-	r, _ := regexp.Compile(psqlVarRE)
-	matches := r.FindAllStringSubmatch(query, -1)
+// paramSpan is a single parameter occurrence in the query text
+type paramSpan struct {
+	name       string
+	start, end int
+	// ordinal is the $N marker the occurrence is rendered as
+	ordinal int
+}
+
+// findNamedParams returns the :name occurrences, skipping reserved names
+func findNamedParams(query string, reserved []string) []paramSpan {
+	var spans []paramSpan
+
+	for _, match := range psqlVarRE.FindAllStringSubmatchIndex(query, -1) {
+		variable := query[match[2]:match[3]]
+
+		if isReservedName(variable, reserved) {
+			continue
+		}
+
+		spans = append(spans, paramSpan{name: variable, start: sigilStart(query, match[0], ':'), end: match[1]})
+	}
 
-	for _, match := range matches {
-		variable := match[1]
+	return spans
+}
+
+// findAtSignParams returns the @name parameters, see WithAtSignParams.
+// Names assigned in the query are MySQL user variables and skipped, as are
+// "@@" system variables.
+func findAtSignParams(query string) []paramSpan {
+	userVars := make(map[string]bool)
+	for _, match := range userVarAssignRE.FindAllStringSubmatch(query, -1) {
+		userVars[match[1]+match[2]+match[3]] = true
+	}
 
-		if isReservedName(variable) {
+	var spans []paramSpan
+	for _, match := range atSignParamRE.FindAllStringSubmatchIndex(query, -1) {
+		if userVars[query[match[2]:match[3]]] {
 			continue
 		}
 
-		if _, ok := mapping[variable]; !ok {
-			mapping[variable] = position
-			namedArgs = append(namedArgs, sql.Named(variable, nil))
+		spans = append(spans, paramSpan{name: query[match[2]:match[3]], start: sigilStart(query, match[0], '@'), end: match[1]})
+	}
+
+	return spans
+}
+
+// sigilStart returns the offset of the sigil of a parameter match. The
+// match includes the preceding character unless it is at the start of the
+// query.
+func sigilStart(query string, start int, sigil byte) int {
+	if query[start] == sigil {
+		return start
+	}
+	return start + 1
+}
+
+// findPositionalParams returns the $N occurrences named argN
+func findPositionalParams(query string) []paramSpan {
+	var spans []paramSpan
+
+	for _, match := range positionalParamRE.FindAllStringSubmatchIndex(query, -1) {
+		n, _ := strconv.Atoi(query[match[2]:match[3]])
+		spans = append(spans, paramSpan{name: fmt.Sprintf("arg%d", n), start: match[0], end: match[1], ordinal: n})
+	}
+
+	return spans
+}
+
+// findQuestionMarkParams returns the ? occurrences named arg1..argN from
+// left to right. The jsonb operators ?| and ?& are skipped.
+func findQuestionMarkParams(query string) []paramSpan {
+	var spans []paramSpan
+
+	for i := 0; i < len(query); i++ {
+		if query[i] != '?' {
+			continue
+		}
+		if i+1 < len(query) && (query[i+1] == '|' || query[i+1] == '&') {
+			i++
+			continue
+		}
+
+		n := len(spans) + 1
+		spans = append(spans, paramSpan{name: fmt.Sprintf("arg%d", n), start: i, end: i + 1, ordinal: n})
+	}
+
+	return spans
+}
+
+// validateSingleParameterStyle rejects queries using more than one
+// parameter style
+func validateSingleParameterStyle(name string, counts map[string]int) error {
+	var styles []string
+	for style, count := range counts {
+		if count > 0 {
+			styles = append(styles, style)
+		}
+	}
+
+	if len(styles) > 1 {
+		sort.Strings(styles)
+		return &MixedParameterStyleError{Name: name, Styles: styles}
+	}
+
+	return nil
+}
+
+// handleNamedParams replaces :name parameters with ordinal markers
+func (q *Query) handleNamedParams(query string, spans []paramSpan, opts options) string {
+	var (
+		position int = 1
+	)
+
+	// TODO: should drop
+	mapping := make(map[string]int)
+	namedArgs := []sql.NamedArg{}
+
+	var args []string
+	for _, span := range spans {
+		args = append(args, span.name)
+
+		if _, ok := mapping[span.name]; !ok {
+			mapping[span.name] = position
+			namedArgs = append(namedArgs, sql.Named(span.name, nil))
 			position++
 		}
 	}
 
-	// replace the variable with ordinal markers
-	for name, ord := range mapping {
-		r, _ := regexp.Compile(fmt.Sprintf(`:["']?%s["']?`, name))
-		query = r.ReplaceAllLiteralString(query, fmt.Sprintf("$%d", ord))
+	if opts.expandRepeatedParams {
+		// every occurrence gets its own ordinal marker and the mapping
+		// points at the first one
+		mapping = make(map[string]int, len(mapping))
+		for i, variable := range args {
+			if _, ok := mapping[variable]; !ok {
+				mapping[variable] = i + 1
+			}
+		}
+
+		for i := range spans {
+			spans[i].ordinal = i + 1
+		}
+		q.ordinals = args
+	} else {
+		for i := range spans {
+			spans[i].ordinal = mapping[spans[i].name]
+		}
+
+		q.ordinals = make([]string, len(namedArgs))
+		for i, arg := range namedArgs {
+			q.ordinals[i] = arg.Name
+		}
 	}
 
-	q.OrdinalQuery = fmt.Sprintf("-- name: %s\n%s", name, query)
+	// replace the occurrences by position, in a single pass, so names
+	// sharing a prefix (":user", ":user_id") and variables in comments are
+	// left intact
+	query = replaceSpans(query, spans, func(_ int, span paramSpan) string {
+		return fmt.Sprintf("$%d", span.ordinal)
+	})
+
 	q.Mapping = mapping
+	q.Args = args
 	q.NamedArgs = namedArgs
+	q.spans = spans
+
+	return query
+}
 
-	return &q
+// handlePositionalParams maps $N parameters to argN. The query is already
+// in ordinal form; the highest N decides the number of arguments.
+func (q *Query) handlePositionalParams(query string, spans []paramSpan) string {
+	maxParam := 0
+	for _, span := range spans {
+		q.Args = append(q.Args, span.name)
+
+		if span.ordinal > maxParam {
+			maxParam = span.ordinal
+		}
+	}
+
+	q.setArgNames(maxParam)
+	q.spans = spans
+
+	return query
+}
+
+// handleQuestionMarkParams numbers ? placeholders from left to right and
+// rewrites them to ordinal markers
+func (q *Query) handleQuestionMarkParams(query string, spans []paramSpan) string {
+	for _, span := range spans {
+		q.Args = append(q.Args, span.name)
+	}
+
+	q.setArgNames(len(spans))
+	q.spans = spans
+
+	return replaceSpans(query, spans, func(_ int, span paramSpan) string {
+		return fmt.Sprintf("$%d", span.ordinal)
+	})
+}
+
+// setArgNames sets the mapping for the positional styles, arg1..argN
+func (q *Query) setArgNames(maxParam int) {
+	q.Mapping = make(map[string]int, maxParam)
+	q.NamedArgs = make([]sql.NamedArg, maxParam)
+	q.ordinals = make([]string, maxParam)
+
+	for i := 0; i < maxParam; i++ {
+		name := fmt.Sprintf("arg%d", i+1)
+		q.Mapping[name] = i + 1
+		q.NamedArgs[i] = sql.Named(name, nil)
+		q.ordinals[i] = name
+	}
 }
 
-// Query returns ordinal query
+// replaceSpans rebuilds the query with every span replaced by the result of
+// fn
+func replaceSpans(query string, spans []paramSpan, fn func(i int, span paramSpan) string) string {
+	var b strings.Builder
+
+	last := 0
+	for i, span := range spans {
+		b.WriteString(query[last:span.start])
+		b.WriteString(fn(i, span))
+		last = span.end
+	}
+	b.WriteString(query[last:])
+
+	return b.String()
+}
+
+// Query returns ordinal query including the name header, see SQL
 func (q *Query) Query() string {
 	return q.OrdinalQuery
 }
@@ -194,34 +1295,237 @@ func (q *Query) RawQuery() string {
 	return q.Raw
 }
 
+// NamedQuery returns Raw, with the parameters as written, under the same
+// "-- name:" header as OrdinalQuery
+func (q *Query) NamedQuery() string {
+	header := q.header
+	if header == "" {
+		header = q.Name
+	}
+
+	return fmt.Sprintf("-- name: %s\n%s", header, q.Raw)
+}
+
+// PreparedName returns a valid PostgreSQL identifier for server-side
+// prepared statements. The name is the sanitized query name followed by a
+// hash of the original name, so it is stable across runs and distinct
+// queries never share it.
+func (q *Query) PreparedName() string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(q.Name) {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') || r == '_' {
+			b.WriteRune(r)
+		} else {
+			b.WriteByte('_')
+		}
+	}
+
+	// identifiers are limited to 63 bytes
+	name := b.String()
+	if len(name) > 50 {
+		name = name[:50]
+	}
+
+	h := fnv.New32a()
+	h.Write([]byte(q.Name))
+
+	return fmt.Sprintf("q_%s_%08x", name, h.Sum32())
+}
+
+// SQL returns OrdinalQuery without the "-- name:" header. This is the form
+// to send to the driver.
+func (q *Query) SQL() string {
+	if !strings.HasPrefix(q.OrdinalQuery, "-- name:") {
+		return q.OrdinalQuery
+	}
+
+	_, statement, _ := strings.Cut(q.OrdinalQuery, "\n")
+	return statement
+}
+
+// GetMetadata returns the metadata value for the key. The lookup is case
+// insensitive.
+func (q *Query) GetMetadata(key string) (string, bool) {
+	value, ok := q.Metadata[normalizeMetadataKey(key)]
+	return value, ok
+}
+
+// MetadataOriginal returns the metadata keyed as authored in the SQL file
+func (q *Query) MetadataOriginal() map[string]string {
+	metadata := make(map[string]string, len(q.metadataOriginal))
+	for k, v := range q.metadataOriginal {
+		metadata[k] = v
+	}
+
+	return metadata
+}
+
 // Prepare the arguments for the ordinal query. Missing arguments will
-// be returned as nil
+// be returned as nil. With WithExpandRepeatedParams a repeated parameter
+// is returned once per occurrence.
 func (q *Query) Prepare(args map[string]interface{}) []interface{} {
-	type kv struct {
-		Name string
-		Ord  int
+	return q.PrepareFor(q.dialect, args)
+}
+
+// PrepareFor prepares the arguments for the query rendered by QueryFor with
+// the same dialect
+func (q *Query) PrepareFor(d Dialect, args map[string]interface{}) []interface{} {
+	names := q.ordinalNames()
+	if d.Positional {
+		names = q.Args
 	}
 
-	// number of components is query and ordinal mapping count
-	components := make([]interface{}, len(q.Mapping))
-	var params []kv
-	for k, v := range q.Mapping {
-		params = append(params, kv{k, v})
+	components := make([]interface{}, len(names))
+	for i, name := range names {
+		components[i] = q.bindValue(args[name])
 	}
 
-	sort.Slice(params, func(i, j int) bool {
-		return params[i].Ord < params[j].Ord
+	return components
+}
+
+// PrepareStrict is Prepare failing when args holds keys that are not
+// parameters of the query, lacks any of its parameters or holds a value
+// not matching the type declared for it in ParamTypes
+func (q *Query) PrepareStrict(args map[string]interface{}) ([]interface{}, error) {
+	if q.parseErr != nil {
+		return nil, q.parseErr
+	}
+
+	var unknown, missing []string
+	for name := range args {
+		if _, ok := q.Mapping[name]; !ok {
+			unknown = append(unknown, name)
+		}
+	}
+	for name := range q.Mapping {
+		if _, ok := args[name]; !ok {
+			missing = append(missing, name)
+		}
+	}
+
+	var problems []string
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		problems = append(problems, "unknown arguments: "+strings.Join(unknown, ", "))
+	}
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		problems = append(problems, "missing arguments: "+strings.Join(missing, ", "))
+	}
+	problems = append(problems, q.checkParamTypes(args)...)
+	if len(problems) > 0 {
+		return nil, fmt.Errorf("Query '%s': %s", q.Name, strings.Join(problems, "; "))
+	}
+
+	return q.Prepare(args), nil
+}
+
+// PrepareNamed returns a copy of NamedArgs with the values taken from args,
+// nil for missing ones. Each parameter appears once, in ordinal order.
+func (q *Query) PrepareNamed(args map[string]interface{}) []sql.NamedArg {
+	named := make([]sql.NamedArg, len(q.NamedArgs))
+	for i, arg := range q.NamedArgs {
+		named[i] = sql.Named(arg.Name, q.bindValue(args[arg.Name]))
+	}
+
+	return named
+}
+
+// PrepareArgs prepares the arguments from values given in ordinal order,
+// the first for $1 and so on, one value per distinct parameter as listed
+// by ParamNames. With WithExpandRepeatedParams a repeated parameter takes
+// one value, which Prepare repeats.
+func (q *Query) PrepareArgs(values ...interface{}) ([]interface{}, error) {
+	if q.parseErr != nil {
+		return nil, q.parseErr
+	}
+
+	names := q.ParamNames()
+	if len(values) != len(names) {
+		return nil, fmt.Errorf("Query '%s' expects %d arguments, got %d", q.Name, len(names), len(values))
+	}
+
+	args := make(map[string]interface{}, len(names))
+	for i, name := range names {
+		args[name] = values[i]
+	}
+
+	return q.Prepare(args), nil
+}
+
+// Err returns the error NewQuery recorded when it could not parse the
+// query, nil otherwise. PrepareStrict and PrepareArgs return it too.
+func (q *Query) Err() error {
+	return q.parseErr
+}
+
+// Clone returns a deep copy of the query. Its maps and slices, e.g.
+// Metadata and NamedArgs, can be changed without affecting q.
+func (q *Query) Clone() *Query {
+	c := *q
+	c.Aliases = slices.Clone(q.Aliases)
+	c.Mapping = maps.Clone(q.Mapping)
+	c.Args = slices.Clone(q.Args)
+	c.NamedArgs = slices.Clone(q.NamedArgs)
+	c.Metadata = maps.Clone(q.Metadata)
+	c.ParamTypes = maps.Clone(q.ParamTypes)
+	c.metadataOriginal = maps.Clone(q.metadataOriginal)
+	c.ordinals = slices.Clone(q.ordinals)
+	c.spans = slices.Clone(q.spans)
+
+	return &c
+}
+
+// ParamCount returns the number of distinct parameters and the number of
+// parameter occurrences, e.g. 2 and 3 for ":a AND :a AND :b"
+func (q *Query) ParamCount() (distinct, total int) {
+	return len(q.Mapping), len(q.Args)
+}
+
+// ParamNames returns the distinct parameter names ordered by their ordinal,
+// the name bound to $1 first. These are the keys Prepare expects.
+func (q *Query) ParamNames() []string {
+	names := make([]string, 0, len(q.Mapping))
+	for name := range q.Mapping {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		return q.Mapping[names[i]] < q.Mapping[names[j]]
 	})
 
-	for i, param := range params {
-		components[i] = args[param.Name]
+	return names
+}
+
+// bindValue wraps slice values with the array binder. Slices always bind to
+// a single placeholder, e.g. "id = ANY(:ids)"; without a binder they are
+// passed to the driver unchanged.
+func (q *Query) bindValue(value interface{}) interface{} {
+	if q.arrayBinder == nil || value == nil {
+		return value
 	}
 
-	return components
+	switch reflect.TypeOf(value).Kind() {
+	case reflect.Slice, reflect.Array:
+		if _, ok := value.([]byte); !ok {
+			return q.arrayBinder(value)
+		}
+	}
+
+	return value
+}
+
+// ordinalNames returns the parameter name for each ordinal marker ($1, $2,
+// ...). Queries not built by NewQuery fall back to the Mapping.
+func (q *Query) ordinalNames() []string {
+	if q.ordinals != nil {
+		return q.ordinals
+	}
+
+	return q.ParamNames()
 }
 
-func isReservedName(name string) bool {
-	for _, res := range reservedNames {
+func isReservedName(name string, reserved []string) bool {
+	for _, res := range reserved {
 		if name == res {
 			return true
 		}
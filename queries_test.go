@@ -1,9 +1,19 @@
 package queries
 
 import (
+	"context"
 	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
 	"reflect"
+	"strings"
+	"sync"
 	"testing"
+	"testing/fstest"
+	"time"
 )
 
 func TestIsReservedName(t *testing.T) {
@@ -20,7 +30,7 @@ func TestIsReservedName(t *testing.T) {
 
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
-			result := isReservedName(tc.name)
+			result := isReservedName(tc.name, DefaultReservedNames)
 			if result != tc.expected {
 				t.Errorf("isReservedName(%s) = %v; expected %v", tc.name, result, tc.expected)
 			}
@@ -73,3 +83,2204 @@ func TestNewQuery(t *testing.T) {
 		})
 	}
 }
+
+func TestParamsInHeader(t *testing.T) {
+	query := "SELECT * FROM users WHERE user_id = :user_id AND status = :status AND org = :user_id"
+
+	testCases := []struct {
+		name        string
+		opts        options
+		query       string
+		expectedOrd string
+	}{
+		{
+			name:        "disabled",
+			opts:        options{},
+			query:       query,
+			expectedOrd: "-- name: get-user\nSELECT * FROM users WHERE user_id = $1 AND status = $2 AND org = $1",
+		},
+		{
+			name:        "enabled",
+			opts:        options{paramsInHeader: true},
+			query:       query,
+			expectedOrd: "-- name: get-user (user_id, status)\nSELECT * FROM users WHERE user_id = $1 AND status = $2 AND org = $1",
+		},
+		{
+			name:        "enabled without params",
+			opts:        options{paramsInHeader: true},
+			query:       "SELECT 1",
+			expectedOrd: "-- name: get-user\nSELECT 1",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			q := mustNewQueryWith(t, "get-user", tc.query, tc.opts)
+			if q.OrdinalQuery != tc.expectedOrd {
+				t.Errorf("OrdinalQuery: got %q, expected %q", q.OrdinalQuery, tc.expectedOrd)
+			}
+		})
+	}
+}
+
+func TestWithParamsInHeader(t *testing.T) {
+	path := writeSQLFile(t, t.TempDir(), "users.sql", "-- name: get-user\nSELECT * FROM users WHERE id = :id AND status = :status\n")
+
+	s := NewQueryStore(WithParamsInHeader(true))
+	if err := s.LoadFromFile(path); err != nil {
+		t.Fatalf("LoadFromFile: %v", err)
+	}
+
+	expected := "-- name: get-user (id, status)\nSELECT * FROM users WHERE id = $1 AND status = $2"
+	if q := s.MustHaveQuery("get-user"); q.OrdinalQuery != expected {
+		t.Errorf("OrdinalQuery: got %q, expected %q", q.OrdinalQuery, expected)
+	}
+}
+
+func mustNewQuery(t testing.TB, name, query string) *Query {
+	t.Helper()
+
+	return mustNewQueryWith(t, name, query, options{})
+}
+
+func mustNewQueryWith(t testing.TB, name, query string, opts options) *Query {
+	t.Helper()
+
+	q, err := newQuery(name, query, opts)
+	if err != nil {
+		t.Fatalf("newQuery(%q): %v", name, err)
+	}
+
+	return q
+}
+
+func writeSQLFile(t *testing.T, dir, name, content string) string {
+	t.Helper()
+
+	path := filepath.Join(dir, name)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+func TestWithMinQueries(t *testing.T) {
+	empty := t.TempDir()
+	populated := t.TempDir()
+	writeSQLFile(t, populated, "users.sql", "-- name: get-user\nSELECT * FROM users WHERE id = :id\n")
+
+	s := NewQueryStore(WithMinQueries(1))
+	if err := s.LoadFromDir(empty); err == nil {
+		t.Errorf("expected error loading empty directory")
+	}
+	if err := s.LoadFromDir(populated); err != nil {
+		t.Errorf("LoadFromDir: %v", err)
+	}
+
+	emptyFile := writeSQLFile(t, empty, "empty.sql", "")
+	if err := s.LoadFromFile(emptyFile); err == nil {
+		t.Errorf("expected error loading file without queries")
+	}
+
+	if err := NewQueryStore().LoadFromDir(empty); err != nil {
+		t.Errorf("LoadFromDir without option: %v", err)
+	}
+}
+
+func TestNewQueryNamedFunctionArguments(t *testing.T) {
+	testCases := []struct {
+		name        string
+		inputQuery  string
+		expectedOrd string
+		expectedSQL []sql.NamedArg
+	}{
+		{
+			name:        "arrow notation",
+			inputQuery:  "SELECT my_func(a => :x, b => :y)",
+			expectedOrd: "-- name: arrow notation\nSELECT my_func(a => $1, b => $2)",
+			expectedSQL: []sql.NamedArg{sql.Named("x", nil), sql.Named("y", nil)},
+		},
+		{
+			name:        "arrow without spaces",
+			inputQuery:  "SELECT my_func(a=>:x, b=>:y)",
+			expectedOrd: "-- name: arrow without spaces\nSELECT my_func(a=>$1, b=>$2)",
+			expectedSQL: []sql.NamedArg{sql.Named("x", nil), sql.Named("y", nil)},
+		},
+		{
+			name:        "legacy assignment notation",
+			inputQuery:  "SELECT my_func(a := :x, b:=:y)",
+			expectedOrd: "-- name: legacy assignment notation\nSELECT my_func(a := $1, b:=$2)",
+			expectedSQL: []sql.NamedArg{sql.Named("x", nil), sql.Named("y", nil)},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			q := mustNewQuery(t, tc.name, tc.inputQuery)
+			if q.OrdinalQuery != tc.expectedOrd {
+				t.Errorf("OrdinalQuery: got %q, expected %q", q.OrdinalQuery, tc.expectedOrd)
+			}
+			if !reflect.DeepEqual(q.NamedArgs, tc.expectedSQL) {
+				t.Errorf("NamedArgs: got %v, expected %v", q.NamedArgs, tc.expectedSQL)
+			}
+		})
+	}
+}
+
+func TestPreviewFile(t *testing.T) {
+	dir := t.TempDir()
+	path := writeSQLFile(t, dir, "users.sql", "-- name: get-user\nSELECT * FROM users WHERE id = :id\n\n-- name: list-users\nSELECT * FROM users\n")
+
+	s := NewQueryStore()
+	preview, err := s.PreviewFile(path)
+	if err != nil {
+		t.Fatalf("PreviewFile: %v", err)
+	}
+
+	if len(preview) != 2 {
+		t.Fatalf("got %d queries, expected 2", len(preview))
+	}
+	if q := preview["get-user"]; q == nil || q.Mapping["id"] != 1 {
+		t.Errorf("get-user was not fully parsed: %+v", q)
+	}
+	if len(s.queries) != 0 {
+		t.Errorf("PreviewFile modified the store: %d queries", len(s.queries))
+	}
+
+	if _, err := s.PreviewFile(filepath.Join(dir, "missing.sql")); err == nil {
+		t.Errorf("expected error for missing file")
+	}
+}
+
+func TestMetadataCasing(t *testing.T) {
+	s := NewQueryStore()
+	path := writeSQLFile(t, t.TempDir(), "users.sql", "-- name: get-user\n-- Description: Fetch a user\n-- TIMEOUT: 2s\nSELECT * FROM users WHERE id = :id\n")
+	if err := s.LoadFromFile(path); err != nil {
+		t.Fatalf("LoadFromFile: %v", err)
+	}
+
+	q := s.MustHaveQuery("get-user")
+	for _, key := range []string{"description", "Description", "DESCRIPTION", " description "} {
+		if value, ok := q.GetMetadata(key); !ok || value != "Fetch a user" {
+			t.Errorf("GetMetadata(%q): got %q, %v", key, value, ok)
+		}
+	}
+
+	normalized := map[string]string{"description": "Fetch a user", "timeout": "2s"}
+	if !reflect.DeepEqual(q.Metadata, normalized) {
+		t.Errorf("Metadata: got %v, expected %v", q.Metadata, normalized)
+	}
+
+	expected := map[string]string{"Description": "Fetch a user", "TIMEOUT": "2s"}
+	if original := q.MetadataOriginal(); !reflect.DeepEqual(original, expected) {
+		t.Errorf("MetadataOriginal: got %v, expected %v", original, expected)
+	}
+
+	// the returned map is a copy
+	q.MetadataOriginal()["Description"] = "changed"
+	if q.MetadataOriginal()["Description"] != "Fetch a user" {
+		t.Errorf("MetadataOriginal returned the internal map")
+	}
+}
+
+func TestQueryNamesCache(t *testing.T) {
+	dir := t.TempDir()
+	users := writeSQLFile(t, dir, "users.sql", "-- name: list-users\nSELECT * FROM users\n\n-- name: get-user\nSELECT * FROM users WHERE id = :id\n")
+	orders := writeSQLFile(t, dir, "orders.sql", "-- name: list-orders\nSELECT * FROM orders\n")
+
+	s := NewQueryStore()
+	if err := s.LoadFromFile(users); err != nil {
+		t.Fatal(err)
+	}
+
+	names := s.QueryNames()
+	if !reflect.DeepEqual(names, []string{"get-user", "list-users"}) {
+		t.Errorf("QueryNames: got %v", names)
+	}
+
+	// modifying the result must not affect the cache
+	names[0] = "changed"
+	if s.QueryNames()[0] != "get-user" {
+		t.Errorf("QueryNames returned the cached slice")
+	}
+
+	if err := s.LoadFromFile(orders); err != nil {
+		t.Fatal(err)
+	}
+	if s.names != nil {
+		t.Errorf("cache was not invalidated on load")
+	}
+	if names := s.QueryNames(); !reflect.DeepEqual(names, []string{"get-user", "list-orders", "list-users"}) {
+		t.Errorf("QueryNames after load: got %v", names)
+	}
+
+	queries := s.Queries()
+	delete(queries, "get-user")
+	if len(s.Queries()) != 3 {
+		t.Errorf("Queries returned the internal map")
+	}
+}
+
+func BenchmarkQueryNames(b *testing.B) {
+	s := NewQueryStore()
+	for i := 0; i < 1000; i++ {
+		name := fmt.Sprintf("query-%04d", i)
+		s.insert(name, mustNewQuery(b, name, "SELECT 1"))
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		s.QueryNames()
+	}
+}
+
+func TestWithSyntaxValidator(t *testing.T) {
+	validator := func(sql string) error {
+		if strings.Contains(sql, "SELEC ") {
+			return errors.New("syntax error at or near \"SELEC\"")
+		}
+		return nil
+	}
+
+	dir := t.TempDir()
+	valid := writeSQLFile(t, dir, "valid.sql", "-- name: get-user\nSELECT * FROM users WHERE id = :id\n")
+	invalid := writeSQLFile(t, dir, "invalid.sql", "-- name: broken\nSELEC * FROM users\n")
+
+	s := NewQueryStore(WithSyntaxValidator(validator))
+	if err := s.LoadFromFile(valid); err != nil {
+		t.Errorf("LoadFromFile(valid): %v", err)
+	}
+
+	err := s.LoadFromFile(invalid)
+	if err == nil || !strings.Contains(err.Error(), "broken") {
+		t.Errorf("expected validation error naming the query, got %v", err)
+	}
+	if _, err := s.Query("broken"); err == nil {
+		t.Errorf("invalid query should not be loaded")
+	}
+}
+
+func TestWithHeaderOnlyDirectives(t *testing.T) {
+	path := writeSQLFile(t, t.TempDir(), "users.sql", "-- name: get-user\nSELECT data->>'name'\n-- name: JSON field\nFROM users;\n")
+
+	s := NewQueryStore(WithHeaderOnlyDirectives(true))
+	if err := s.LoadFromFile(path); err != nil {
+		t.Fatal(err)
+	}
+
+	if names := s.QueryNames(); !reflect.DeepEqual(names, []string{"get-user"}) {
+		t.Errorf("QueryNames: got %v", names)
+	}
+}
+
+func TestExpandRepeatedParams(t *testing.T) {
+	query := "SELECT * FROM users WHERE id = :id OR parent_id = :id AND status = :status"
+	args := map[string]interface{}{"id": 7, "status": "active"}
+
+	testCases := []struct {
+		name            string
+		opts            options
+		expectedOrd     string
+		expectedArgs    []interface{}
+		expectedMapping map[string]int
+	}{
+		{
+			name:            "collapse",
+			opts:            options{},
+			expectedOrd:     "-- name: get-user\nSELECT * FROM users WHERE id = $1 OR parent_id = $1 AND status = $2",
+			expectedArgs:    []interface{}{7, "active"},
+			expectedMapping: map[string]int{"id": 1, "status": 2},
+		},
+		{
+			name:            "expand",
+			opts:            options{expandRepeatedParams: true},
+			expectedOrd:     "-- name: get-user\nSELECT * FROM users WHERE id = $1 OR parent_id = $2 AND status = $3",
+			expectedArgs:    []interface{}{7, 7, "active"},
+			expectedMapping: map[string]int{"id": 1, "status": 3},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			q := mustNewQueryWith(t, "get-user", query, tc.opts)
+			if q.OrdinalQuery != tc.expectedOrd {
+				t.Errorf("OrdinalQuery: got %q, expected %q", q.OrdinalQuery, tc.expectedOrd)
+			}
+			if prepared := q.Prepare(args); !reflect.DeepEqual(prepared, tc.expectedArgs) {
+				t.Errorf("Prepare: got %v, expected %v", prepared, tc.expectedArgs)
+			}
+			if !reflect.DeepEqual(q.Args, []string{"id", "id", "status"}) {
+				t.Errorf("Args: got %v", q.Args)
+			}
+			if !reflect.DeepEqual(q.Mapping, tc.expectedMapping) {
+				t.Errorf("Mapping: got %v, expected %v", q.Mapping, tc.expectedMapping)
+			}
+		})
+	}
+}
+
+func TestWithPreprocessor(t *testing.T) {
+	dir := t.TempDir()
+	path := writeSQLFile(t, dir, "users.sql", "-- name: get-user\nSELECT * FROM {{schema}}.users WHERE id = :{{key}}\n")
+
+	var seenPath string
+	s := NewQueryStore(WithPreprocessor(func(path, sql string) (string, error) {
+		seenPath = path
+		return strings.NewReplacer("{{schema}}", "tenant_a", "{{key}}", "user_id").Replace(sql), nil
+	}))
+	if err := s.LoadFromFile(path); err != nil {
+		t.Fatal(err)
+	}
+
+	q := s.MustHaveQuery("get-user")
+	if q.Raw != "SELECT * FROM tenant_a.users WHERE id = :user_id" {
+		t.Errorf("Raw: got %q", q.Raw)
+	}
+	if !reflect.DeepEqual(q.Args, []string{"user_id"}) {
+		t.Errorf("parameters were detected before preprocessing: %v", q.Args)
+	}
+	if seenPath != path {
+		t.Errorf("preprocessor got path %q, expected %q", seenPath, path)
+	}
+
+	failing := NewQueryStore(WithPreprocessor(func(path, sql string) (string, error) {
+		return "", errors.New("unknown macro")
+	}))
+	if err := failing.LoadFromFile(path); err == nil {
+		t.Errorf("expected preprocessor error to abort the load")
+	}
+}
+
+func TestNewQueryIgnoresCommentedParams(t *testing.T) {
+	q := mustNewQuery(t, "get-user", "-- filter by :status later\nSELECT * FROM users WHERE id = :id -- or :email")
+
+	if !reflect.DeepEqual(q.Args, []string{"id"}) {
+		t.Errorf("Args: got %v, expected [id]", q.Args)
+	}
+	if !reflect.DeepEqual(q.Mapping, map[string]int{"id": 1}) {
+		t.Errorf("Mapping: got %v", q.Mapping)
+	}
+}
+
+func TestNewQueryTypeCasts(t *testing.T) {
+	testCases := []struct {
+		name         string
+		inputQuery   string
+		expectedOrd  string
+		expectedArgs []string
+	}{
+		{
+			name:         "cast on column",
+			inputQuery:   "SELECT id::text FROM users WHERE id = :id",
+			expectedOrd:  "SELECT id::text FROM users WHERE id = $1",
+			expectedArgs: []string{"id"},
+		},
+		{
+			name:         "param named like the cast type",
+			inputQuery:   "SELECT id::text FROM users WHERE name = :text",
+			expectedOrd:  "SELECT id::text FROM users WHERE name = $1",
+			expectedArgs: []string{"text"},
+		},
+		{
+			name:         "cast on param",
+			inputQuery:   "SELECT * FROM orders WHERE total > :total::numeric AND tags = :tags::text[]",
+			expectedOrd:  "SELECT * FROM orders WHERE total > $1::numeric AND tags = $2::text[]",
+			expectedArgs: []string{"total", "tags"},
+		},
+		{
+			name:         "param and cast in one expression",
+			inputQuery:   "SELECT (created_at + :delay::interval)::timestamptz, value::numeric FROM events WHERE kind = :kind",
+			expectedOrd:  "SELECT (created_at + $1::interval)::timestamptz, value::numeric FROM events WHERE kind = $2",
+			expectedArgs: []string{"delay", "kind"},
+		},
+		{
+			name:         "casts only",
+			inputQuery:   "SELECT value::numeric, col::text[], (now())::timestamptz FROM t",
+			expectedOrd:  "SELECT value::numeric, col::text[], (now())::timestamptz FROM t",
+			expectedArgs: nil,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			q := mustNewQuery(t, tc.name, tc.inputQuery)
+			if q.SQL() != tc.expectedOrd {
+				t.Errorf("OrdinalQuery: got %q, expected %q", q.SQL(), tc.expectedOrd)
+			}
+			if !reflect.DeepEqual(q.Args, tc.expectedArgs) {
+				t.Errorf("Args: got %v, expected %v", q.Args, tc.expectedArgs)
+			}
+		})
+	}
+}
+
+func TestNewQueryPositionalParams(t *testing.T) {
+	testCases := []struct {
+		name            string
+		opts            options
+		inputQuery      string
+		expectedOrd     string
+		expectedArgs    []string
+		expectedMapping map[string]int
+	}{
+		{
+			name:            "dollar",
+			inputQuery:      "SELECT * FROM users WHERE id = $1 OR parent_id = $1 AND status = $2",
+			expectedOrd:     "SELECT * FROM users WHERE id = $1 OR parent_id = $1 AND status = $2",
+			expectedArgs:    []string{"arg1", "arg1", "arg2"},
+			expectedMapping: map[string]int{"arg1": 1, "arg2": 2},
+		},
+		{
+			name:            "question marks",
+			opts:            options{questionMarkParams: true},
+			inputQuery:      "INSERT INTO t (a,b) VALUES (?, ?)",
+			expectedOrd:     "INSERT INTO t (a,b) VALUES ($1, $2)",
+			expectedArgs:    []string{"arg1", "arg2"},
+			expectedMapping: map[string]int{"arg1": 1, "arg2": 2},
+		},
+		{
+			name:            "question marks in literals and jsonb operators",
+			opts:            options{questionMarkParams: true},
+			inputQuery:      "SELECT 'why?' FROM t WHERE data ?| array['a'] AND data ?& array['b'] AND id = ?",
+			expectedOrd:     "SELECT 'why?' FROM t WHERE data ?| array['a'] AND data ?& array['b'] AND id = $1",
+			expectedArgs:    []string{"arg1"},
+			expectedMapping: map[string]int{"arg1": 1},
+		},
+		{
+			name:            "question marks disabled",
+			inputQuery:      "SELECT * FROM t WHERE data ? 'key'",
+			expectedOrd:     "SELECT * FROM t WHERE data ? 'key'",
+			expectedArgs:    nil,
+			expectedMapping: map[string]int{},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			q := mustNewQueryWith(t, tc.name, tc.inputQuery, tc.opts)
+			if q.SQL() != tc.expectedOrd {
+				t.Errorf("OrdinalQuery: got %q, expected %q", q.SQL(), tc.expectedOrd)
+			}
+			if !reflect.DeepEqual(q.Args, tc.expectedArgs) {
+				t.Errorf("Args: got %v, expected %v", q.Args, tc.expectedArgs)
+			}
+			if !reflect.DeepEqual(q.Mapping, tc.expectedMapping) {
+				t.Errorf("Mapping: got %v, expected %v", q.Mapping, tc.expectedMapping)
+			}
+			if len(q.NamedArgs) != len(tc.expectedMapping) {
+				t.Errorf("NamedArgs: got %v", q.NamedArgs)
+			}
+		})
+	}
+}
+
+func TestNewQueryMixedParameterStyles(t *testing.T) {
+	testCases := []struct {
+		name  string
+		opts  options
+		query string
+	}{
+		{name: "named and dollar", query: "SELECT * FROM t WHERE a = :a AND b = $1"},
+		{name: "named and question mark", opts: options{questionMarkParams: true}, query: "SELECT * FROM t WHERE a = :a AND b = ?"},
+		{name: "dollar and question mark", opts: options{questionMarkParams: true}, query: "SELECT * FROM t WHERE a = $1 AND b = ?"},
+		{name: "named and at sign", opts: options{atSignParams: true}, query: "SELECT * FROM t WHERE a = :a AND b = @b"},
+		{name: "dollar and at sign", opts: options{atSignParams: true}, query: "SELECT * FROM t WHERE a = $1 AND b = @b"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := newQuery(tc.name, tc.query, tc.opts); err == nil {
+				t.Errorf("expected error for mixed parameter styles")
+			}
+		})
+	}
+
+	path := writeSQLFile(t, t.TempDir(), "mixed.sql", "-- name: mixed\nSELECT * FROM t WHERE a = :a AND b = $1\n")
+	if err := NewQueryStore().LoadFromFile(path); err == nil || !strings.Contains(err.Error(), "mixed") {
+		t.Errorf("LoadFromFile: expected mixed style error, got %v", err)
+	}
+}
+
+func TestWithQuestionMarkParams(t *testing.T) {
+	path := writeSQLFile(t, t.TempDir(), "t.sql", "-- name: insert-t\nINSERT INTO t (a,b) VALUES (?, ?)\n")
+
+	s := NewQueryStore(WithQuestionMarkParams(true))
+	if err := s.LoadFromFile(path); err != nil {
+		t.Fatal(err)
+	}
+
+	q := s.MustHaveQuery("insert-t")
+	if args := q.Prepare(map[string]interface{}{"arg1": "x", "arg2": "y"}); !reflect.DeepEqual(args, []interface{}{"x", "y"}) {
+		t.Errorf("Prepare: got %v", args)
+	}
+}
+
+func TestPrepareArrayParams(t *testing.T) {
+	type wrapped struct{ value interface{} }
+	binder := func(v interface{}) interface{} { return wrapped{v} }
+
+	query := "SELECT * FROM users WHERE id = ANY(:ids) AND status = :status AND avatar = :avatar"
+	args := map[string]interface{}{
+		"ids":    []int64{1, 2, 3},
+		"status": "active",
+		"avatar": []byte("png"),
+	}
+
+	plain := mustNewQuery(t, "plain", query)
+	if plain.SQL() != "SELECT * FROM users WHERE id = ANY($1) AND status = $2 AND avatar = $3" {
+		t.Errorf("OrdinalQuery: got %q", plain.SQL())
+	}
+	expected := []interface{}{[]int64{1, 2, 3}, "active", []byte("png")}
+	if prepared := plain.Prepare(args); !reflect.DeepEqual(prepared, expected) {
+		t.Errorf("Prepare: got %v, expected %v", prepared, expected)
+	}
+
+	bound := mustNewQueryWith(t, "bound", query, options{arrayBinder: binder})
+	expected = []interface{}{wrapped{[]int64{1, 2, 3}}, "active", []byte("png")}
+	if prepared := bound.Prepare(args); !reflect.DeepEqual(prepared, expected) {
+		t.Errorf("Prepare with binder: got %v, expected %v", prepared, expected)
+	}
+
+	s := NewQueryStore(WithArrayBinder(binder))
+	if s.opts.arrayBinder == nil {
+		t.Errorf("WithArrayBinder was not applied")
+	}
+}
+
+func TestPrepareStrict(t *testing.T) {
+	q := mustNewQuery(t, "get-user", "SELECT * FROM users WHERE id = :user_id AND status = :status")
+
+	testCases := []struct {
+		name          string
+		args          map[string]interface{}
+		expectedArgs  []interface{}
+		expectedError string
+	}{
+		{
+			name:         "all arguments",
+			args:         map[string]interface{}{"user_id": 1, "status": "active"},
+			expectedArgs: []interface{}{1, "active"},
+		},
+		{
+			name:          "extra keys",
+			args:          map[string]interface{}{"user_id": 1, "status": "active", "userid": 1, "limit": 10},
+			expectedError: "Query 'get-user': unknown arguments: limit, userid",
+		},
+		{
+			name:          "missing keys",
+			args:          map[string]interface{}{"user_id": 1},
+			expectedError: "Query 'get-user': missing arguments: status",
+		},
+		{
+			name:          "both",
+			args:          map[string]interface{}{"userid": 1, "status": "active"},
+			expectedError: "Query 'get-user': unknown arguments: userid; missing arguments: user_id",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			args, err := q.PrepareStrict(tc.args)
+			if tc.expectedError != "" {
+				if err == nil || err.Error() != tc.expectedError {
+					t.Errorf("PrepareStrict: got error %v, expected %q", err, tc.expectedError)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("PrepareStrict: %v", err)
+			}
+			if !reflect.DeepEqual(args, tc.expectedArgs) {
+				t.Errorf("PrepareStrict: got %v, expected %v", args, tc.expectedArgs)
+			}
+			if prepared := q.Prepare(tc.args); !reflect.DeepEqual(args, prepared) {
+				t.Errorf("PrepareStrict: got %v, Prepare got %v", args, prepared)
+			}
+		})
+	}
+}
+
+func TestPrepareNamed(t *testing.T) {
+	q := mustNewQuery(t, "list-users", "SELECT * FROM users WHERE status = :status AND (team_id = :team_id OR owner_id = :team_id) AND id > :after")
+
+	named := q.PrepareNamed(map[string]interface{}{"team_id": 7, "status": "active", "unused": true})
+
+	expected := []sql.NamedArg{
+		sql.Named("status", "active"),
+		sql.Named("team_id", 7),
+		sql.Named("after", nil),
+	}
+	if !reflect.DeepEqual(named, expected) {
+		t.Fatalf("PrepareNamed: got %v, expected %v", named, expected)
+	}
+
+	for i, arg := range named {
+		if q.Mapping[arg.Name] != i+1 {
+			t.Errorf("PrepareNamed: %s at %d, mapped to ordinal %d", arg.Name, i+1, q.Mapping[arg.Name])
+		}
+	}
+
+	if q.NamedArgs[0].Value != nil {
+		t.Errorf("PrepareNamed modified NamedArgs: %v", q.NamedArgs)
+	}
+}
+
+func TestPrepareArgs(t *testing.T) {
+	testCases := []struct {
+		name          string
+		inputQuery    string
+		opts          options
+		values        []interface{}
+		expectedArgs  []interface{}
+		expectedError bool
+	}{
+		{name: "named", inputQuery: "SELECT * FROM users WHERE id = :id AND status = :status", values: []interface{}{1, "active"}, expectedArgs: []interface{}{1, "active"}},
+		{name: "repeated", inputQuery: "SELECT * FROM t WHERE a = :x OR b = :x AND c = :y", values: []interface{}{1, 2}, expectedArgs: []interface{}{1, 2}},
+		{name: "positional", inputQuery: "SELECT * FROM t WHERE b = $2 AND a = $1", values: []interface{}{"a", "b"}, expectedArgs: []interface{}{"a", "b"}},
+		{name: "no params", inputQuery: "SELECT 1", values: nil, expectedArgs: []interface{}{}},
+		{name: "too few", inputQuery: "SELECT * FROM users WHERE id = :id AND status = :status", values: []interface{}{1}, expectedError: true},
+		{name: "too many", inputQuery: "SELECT * FROM users WHERE id = :id", values: []interface{}{1, 2}, expectedError: true},
+		{name: "expanded", inputQuery: "SELECT * FROM t WHERE a = :x OR b = :x AND c = :y", opts: options{expandRepeatedParams: true}, values: []interface{}{1, 2}, expectedArgs: []interface{}{1, 1, 2}},
+		{name: "expanded per occurrence", inputQuery: "SELECT * FROM t WHERE a = :x OR b = :x", opts: options{expandRepeatedParams: true}, values: []interface{}{1, 1}, expectedError: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			q := mustNewQueryWith(t, tc.name, tc.inputQuery, tc.opts)
+
+			args, err := q.PrepareArgs(tc.values...)
+			if tc.expectedError {
+				if err == nil {
+					t.Errorf("PrepareArgs: expected error, got %v", args)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("PrepareArgs: %v", err)
+			}
+			if !reflect.DeepEqual(args, tc.expectedArgs) {
+				t.Errorf("PrepareArgs: got %v, expected %v", args, tc.expectedArgs)
+			}
+		})
+	}
+}
+
+func TestSQL(t *testing.T) {
+	testCases := []struct {
+		name        string
+		inputQuery  string
+		expectedSQL string
+	}{
+		{name: "named", inputQuery: "SELECT * FROM users WHERE id = :id", expectedSQL: "SELECT * FROM users WHERE id = $1"},
+		{name: "positional", inputQuery: "SELECT * FROM users WHERE id = $1", expectedSQL: "SELECT * FROM users WHERE id = $1"},
+		{name: "multi line", inputQuery: "SELECT *\nFROM users\nWHERE id = :id", expectedSQL: "SELECT *\nFROM users\nWHERE id = $1"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			q := mustNewQuery(t, tc.name, tc.inputQuery)
+
+			if q.SQL() != tc.expectedSQL {
+				t.Errorf("SQL: got %q, expected %q", q.SQL(), tc.expectedSQL)
+			}
+
+			expectedQuery := "-- name: " + tc.name + "\n" + tc.expectedSQL
+			if q.Query() != expectedQuery || q.OrdinalQuery != expectedQuery {
+				t.Errorf("Query: got %q, expected %q", q.Query(), expectedQuery)
+			}
+		})
+	}
+}
+
+func TestNamedParamsSharingPrefix(t *testing.T) {
+	testCases := []struct {
+		name        string
+		inputQuery  string
+		expectedSQL string
+	}{
+		{
+			name:        "id and id2",
+			inputQuery:  "SELECT * FROM t WHERE a = :id2 AND b = :id AND c = :id2",
+			expectedSQL: "SELECT * FROM t WHERE a = $1 AND b = $2 AND c = $1",
+		},
+		{
+			name:        "user and user_name",
+			inputQuery:  "SELECT * FROM users WHERE id = :user AND name = :user_name",
+			expectedSQL: "SELECT * FROM users WHERE id = $1 AND name = $2",
+		},
+		{
+			name:        "user_name first",
+			inputQuery:  "SELECT * FROM users WHERE name = :user_name OR id = :user",
+			expectedSQL: "SELECT * FROM users WHERE name = $1 OR id = $2",
+		},
+		{
+			name:        "comments",
+			inputQuery:  "SELECT id -- filter by :user\nFROM users WHERE id = :user",
+			expectedSQL: "SELECT id -- filter by :user\nFROM users WHERE id = $1",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			for i := 0; i < 20; i++ {
+				q := mustNewQuery(t, tc.name, tc.inputQuery)
+				if q.SQL() != tc.expectedSQL {
+					t.Fatalf("SQL: got %q, expected %q", q.SQL(), tc.expectedSQL)
+				}
+			}
+		})
+	}
+}
+
+func TestNewQueryDeterministic(t *testing.T) {
+	var conditions []string
+	for i := 0; i < 40; i++ {
+		conditions = append(conditions, fmt.Sprintf("c%d = :p%d", i, 39-i))
+	}
+	query := "SELECT * FROM t WHERE " + strings.Join(conditions, " AND ") + " OR c0 = :p39 OR c1 = :p3"
+
+	for _, opts := range []options{{}, {expandRepeatedParams: true}, {paramsInHeader: true}} {
+		first := mustNewQueryWith(t, "many-params", query, opts)
+		if first.Mapping["p39"] != 1 || first.Mapping["p0"] != 40 {
+			t.Errorf("Mapping does not follow first appearance: %v", first.Mapping)
+		}
+
+		for i := 0; i < 50; i++ {
+			q := mustNewQueryWith(t, "many-params", query, opts)
+			if q.OrdinalQuery != first.OrdinalQuery {
+				t.Fatalf("OrdinalQuery differs between runs:\n%s\n%s", first.OrdinalQuery, q.OrdinalQuery)
+			}
+			if !reflect.DeepEqual(q.NamedArgs, first.NamedArgs) {
+				t.Fatalf("NamedArgs differ between runs: %v vs %v", first.NamedArgs, q.NamedArgs)
+			}
+		}
+	}
+}
+
+func TestWithStripComments(t *testing.T) {
+	testCases := []struct {
+		name        string
+		inputQuery  string
+		expectedSQL string
+	}{
+		{
+			name:        "trailing comment",
+			inputQuery:  "SELECT id -- the id\nFROM users\nWHERE id = :id -- by primary key",
+			expectedSQL: "SELECT id\nFROM users\nWHERE id = $1",
+		},
+		{
+			name:        "comment lines",
+			inputQuery:  "SELECT id\n-- only active users\nFROM users\n  /* multi\n     line */\nWHERE active",
+			expectedSQL: "SELECT id\nFROM users\nWHERE active",
+		},
+		{
+			name:        "inline block comment",
+			inputQuery:  "SELECT id/* pk */FROM users WHERE id = /* param */ :id",
+			expectedSQL: "SELECT id FROM users WHERE id = $1",
+		},
+		{
+			name:        "markers in literals",
+			inputQuery:  "SELECT '-- not a comment', '/* nor this */' FROM t WHERE id = :id",
+			expectedSQL: "SELECT '-- not a comment', '/* nor this */' FROM t WHERE id = $1",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			q := mustNewQueryWith(t, "q", tc.inputQuery, options{stripComments: true})
+			if q.SQL() != tc.expectedSQL {
+				t.Errorf("SQL: got %q, expected %q", q.SQL(), tc.expectedSQL)
+			}
+			if q.Raw != tc.inputQuery {
+				t.Errorf("Raw: got %q, expected %q", q.Raw, tc.inputQuery)
+			}
+		})
+	}
+
+	s := NewQueryStore(WithStripComments(true), WithDialect(DialectMySQL))
+	if err := s.LoadFromString("users.sql", "-- name: get-user\nSELECT * FROM users -- all columns\nWHERE id = :id\n"); err != nil {
+		t.Fatal(err)
+	}
+	if sql := s.MustHaveQuery("get-user").SQL(); sql != "SELECT * FROM users\nWHERE id = ?" {
+		t.Errorf("SQL with dialect: got %q", sql)
+	}
+}
+
+func TestNewQueryIgnoresBlockComments(t *testing.T) {
+	testCases := []struct {
+		name         string
+		inputQuery   string
+		expectedArgs []string
+	}{
+		{name: "single line", inputQuery: "SELECT /* :fake */ id FROM t WHERE id = :real", expectedArgs: []string{"real"}},
+		{name: "multi line", inputQuery: "SELECT id\n/* filter by\n   :status */\nFROM t WHERE id = :real", expectedArgs: []string{"real"}},
+		{name: "positional", inputQuery: "SELECT id /* $2 */ FROM t WHERE id = $1", expectedArgs: []string{"arg1"}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			q := mustNewQuery(t, tc.name, tc.inputQuery)
+			if !reflect.DeepEqual(q.Args, tc.expectedArgs) {
+				t.Errorf("Args: got %v, expected %v", q.Args, tc.expectedArgs)
+			}
+		})
+	}
+}
+
+func TestReservedNames(t *testing.T) {
+	testCases := []struct {
+		name         string
+		opts         options
+		inputQuery   string
+		expectedArgs []string
+	}{
+		{
+			name:         "time format",
+			inputQuery:   "SELECT to_char(now(), 'HH24:MI:SS') WHERE id = :id",
+			expectedArgs: []string{"id"},
+		},
+		{
+			name:         "twelve hour format",
+			inputQuery:   "SELECT to_char(now(), 'HH12:MI:SS AM')",
+			expectedArgs: nil,
+		},
+		{
+			name:         "date format",
+			inputQuery:   "SELECT to_char(d, 'YYYY-MM-DD') FROM t WHERE d > :since",
+			expectedArgs: []string{"since"},
+		},
+		{
+			name:         "custom reserved names",
+			opts:         options{reservedNames: []string{"TENANT"}},
+			inputQuery:   "SELECT ':TENANT', to_char(now(), 'HH24:MI')",
+			expectedArgs: []string{"MI"},
+		},
+		{
+			name:         "no reserved names",
+			opts:         options{reservedNames: []string{}},
+			inputQuery:   "SELECT to_char(now(), 'HH24:MI')",
+			expectedArgs: []string{"MI"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			q := mustNewQueryWith(t, tc.name, tc.inputQuery, tc.opts)
+			if !reflect.DeepEqual(q.Args, tc.expectedArgs) {
+				t.Errorf("Args: got %v, expected %v", q.Args, tc.expectedArgs)
+			}
+		})
+	}
+
+	s := NewQueryStore(WithReservedNames("TENANT"))
+	if !reflect.DeepEqual(s.opts.reserved(), []string{"TENANT"}) {
+		t.Errorf("WithReservedNames was not applied: %v", s.opts.reserved())
+	}
+}
+
+func TestLoadFromReader(t *testing.T) {
+	s := NewQueryStore()
+
+	err := s.LoadFromReader("users.sql", strings.NewReader("-- name: get-user\nSELECT * FROM users WHERE id = :id\n"))
+	if err != nil {
+		t.Fatalf("LoadFromReader: %v", err)
+	}
+	if q := s.MustHaveQuery("get-user"); q.Path != "users.sql" || q.Mapping["id"] != 1 {
+		t.Errorf("unexpected query: %+v", q)
+	}
+
+	// without a name directive the name is used
+	if err := s.LoadFromReader("count-users.sql", strings.NewReader("SELECT count(*) FROM users")); err != nil {
+		t.Fatalf("LoadFromReader: %v", err)
+	}
+	if _, err := s.Query("count-users"); err != nil {
+		t.Errorf("fallback name was not used: %v", err)
+	}
+
+	err = s.LoadFromReader("other.sql", strings.NewReader("-- name: get-user\nSELECT 1\n"))
+	if err == nil || !strings.Contains(err.Error(), "already exists") {
+		t.Errorf("expected duplicate error, got %v", err)
+	}
+}
+
+func TestLoadFromString(t *testing.T) {
+	s := NewQueryStore()
+
+	err := s.LoadFromString("users", `-- name: get-user
+SELECT * FROM users WHERE id = :id
+
+-- name: list-users
+SELECT * FROM users
+
+-- name: delete-user
+DELETE FROM users WHERE id = :id
+`)
+	if err != nil {
+		t.Fatalf("LoadFromString: %v", err)
+	}
+
+	if names := s.QueryNames(); !reflect.DeepEqual(names, []string{"delete-user", "get-user", "list-users"}) {
+		t.Errorf("QueryNames: got %v", names)
+	}
+	for _, name := range s.QueryNames() {
+		if q := s.MustHaveQuery(name); q.Path != "users" {
+			t.Errorf("%s: Path got %q, expected users", name, q.Path)
+		}
+	}
+
+	if err := s.LoadFromString("more", "-- name: list-users\nSELECT 1\n"); err == nil {
+		t.Errorf("expected duplicate error across calls")
+	}
+}
+
+//go:embed testdata/embed
+var testFS embed.FS
+
+func TestLoadFromEmbed(t *testing.T) {
+	s := NewQueryStore()
+	if err := s.LoadFromEmbed(testFS, "testdata/embed/sql"); err != nil {
+		t.Fatalf("LoadFromEmbed: %v", err)
+	}
+
+	if names := s.QueryNames(); !reflect.DeepEqual(names, []string{"get-user", "list-orders"}) {
+		t.Errorf("QueryNames: got %v", names)
+	}
+
+	err := s.LoadFromEmbed(testFS, "testdata/embed/sql/users")
+	if err == nil || !strings.Contains(err.Error(), "already exists") {
+		t.Errorf("expected duplicate error, got %v", err)
+	}
+
+	if err := NewQueryStore().LoadFromEmbed(testFS, "testdata/missing"); err == nil {
+		t.Errorf("expected error for missing path")
+	}
+}
+
+func TestLoadFromFS(t *testing.T) {
+	fsys := fstest.MapFS{
+		"sql/users/get.sql":     {Data: []byte("-- name: get-user\nSELECT * FROM users WHERE id = :id\n")},
+		"sql/users/list.SQL":    {Data: []byte("-- name: list-users\nSELECT * FROM users\n")},
+		"sql/orders/a/b/x.sql":  {Data: []byte("-- name: list-orders\nSELECT * FROM orders\n")},
+		"sql/orders/notes.txt":  {Data: []byte("-- name: not-loaded\nSELECT 1\n")},
+		"sql/orders/backup.bak": {Data: []byte("garbage")},
+		"other/skip.sql":        {Data: []byte("-- name: outside-root\nSELECT 1\n")},
+	}
+
+	s := NewQueryStore()
+	if err := s.LoadFromFS(fsys, "sql"); err != nil {
+		t.Fatalf("LoadFromFS: %v", err)
+	}
+
+	if names := s.QueryNames(); !reflect.DeepEqual(names, []string{"get-user", "list-orders", "list-users"}) {
+		t.Errorf("QueryNames: got %v", names)
+	}
+
+	if err := s.LoadFromFS(fsys, "missing"); err == nil {
+		t.Errorf("expected error for missing root")
+	}
+}
+
+func TestConcurrentLoadAndRead(t *testing.T) {
+	dir := t.TempDir()
+	for i := 0; i < 20; i++ {
+		writeSQLFile(t, dir, fmt.Sprintf("q%02d.sql", i), fmt.Sprintf("-- name: query-%02d\nSELECT * FROM t WHERE id = :id\n", i))
+	}
+
+	s := NewQueryStore()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(2)
+
+		go func(i int) {
+			defer wg.Done()
+			if err := s.LoadFromFile(filepath.Join(dir, fmt.Sprintf("q%02d.sql", i))); err != nil {
+				t.Error(err)
+			}
+		}(i)
+
+		go func(i int) {
+			defer wg.Done()
+			s.Query(fmt.Sprintf("query-%02d", i))
+			s.QueryNames()
+			s.Queries()
+			s.Lint()
+		}(i)
+	}
+	wg.Wait()
+
+	if names := s.QueryNames(); len(names) != 20 {
+		t.Errorf("got %d queries, expected 20", len(names))
+	}
+}
+
+func TestSortedReadsWhileWriting(t *testing.T) {
+	readWhileWriting(t, func(s *QueryStore) {
+		s.QueryNames()
+		s.Lint()
+	})
+}
+
+// readWhileWriting runs read in a loop while another goroutine adds and
+// removes queries, failing when the two deadlock
+func readWhileWriting(t *testing.T, read func(s *QueryStore)) {
+	t.Helper()
+
+	s := NewQueryStore()
+	if err := s.LoadFromString("users.sql", "-- name: get-user\nSELECT * FROM users WHERE id = :id\n"); err != nil {
+		t.Fatalf("LoadFromString: %v", err)
+	}
+
+	deadline := time.Now().Add(300 * time.Millisecond)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+
+		var wg sync.WaitGroup
+		wg.Add(3)
+		go func() {
+			defer wg.Done()
+			for time.Now().Before(deadline) {
+				s.LoadFromString("orders.sql", "-- name: list-orders\nSELECT * FROM orders\n")
+				s.Remove("list-orders")
+			}
+		}()
+		for i := 0; i < 2; i++ {
+			go func() {
+				defer wg.Done()
+				for time.Now().Before(deadline) {
+					read(s)
+				}
+			}()
+		}
+		wg.Wait()
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(20 * time.Second):
+		t.Fatal("readers and writers deadlocked")
+	}
+}
+
+func TestHasAndLen(t *testing.T) {
+	s := NewQueryStore()
+	if s.Len() != 0 || s.Has("get-user") {
+		t.Errorf("empty store: Len %d, Has %v", s.Len(), s.Has("get-user"))
+	}
+
+	if err := s.LoadFromString("users.sql", "-- name: get-user\nSELECT 1\n\n-- name: list-users\nSELECT 2\n"); err != nil {
+		t.Fatal(err)
+	}
+
+	if s.Len() != 2 {
+		t.Errorf("Len: got %d, expected 2", s.Len())
+	}
+	if !s.Has("get-user") || !s.Has("list-users") {
+		t.Errorf("Has: loaded queries not found")
+	}
+	if s.Has("delete-user") || s.Has("") {
+		t.Errorf("Has: unknown query found")
+	}
+}
+
+func TestRemoveAndClear(t *testing.T) {
+	s := NewQueryStore()
+	if err := s.LoadFromString("users.sql", "-- name: get-user\nSELECT 1\n\n-- name: list-users\nSELECT 2\n"); err != nil {
+		t.Fatal(err)
+	}
+	s.QueryNames()
+
+	if !s.Remove("get-user") {
+		t.Errorf("Remove: expected true for loaded query")
+	}
+	if s.Remove("get-user") {
+		t.Errorf("Remove: expected false for removed query")
+	}
+	if _, err := s.Query("get-user"); err == nil {
+		t.Errorf("Query: removed query still found")
+	}
+	if s.Len() != 1 {
+		t.Errorf("Len: got %d, expected 1", s.Len())
+	}
+	if names := s.QueryNames(); !reflect.DeepEqual(names, []string{"list-users"}) {
+		t.Errorf("QueryNames: got %v", names)
+	}
+
+	s.Clear()
+	if s.Len() != 0 || len(s.QueryNames()) != 0 {
+		t.Errorf("Clear: %d queries left", s.Len())
+	}
+
+	// the names can be loaded again
+	if err := s.LoadFromString("users.sql", "-- name: get-user\nSELECT 1\n"); err != nil {
+		t.Errorf("LoadFromString after Clear: %v", err)
+	}
+}
+
+func TestQueriesByPath(t *testing.T) {
+	dir := t.TempDir()
+	users := writeSQLFile(t, dir, "users.sql", "-- name: list-users\nSELECT 1\n\n-- name: count-users\nSELECT 2\n")
+	writeSQLFile(t, dir, "items.sql", "-- name: list-items\nSELECT 3\n")
+
+	s := NewQueryStore()
+	if err := s.LoadFromDir(dir); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.LoadFromEmbed(testFS, "testdata/embed/sql"); err != nil {
+		t.Fatal(err)
+	}
+
+	var names []string
+	for _, q := range s.QueriesByPath(users) {
+		names = append(names, q.Name)
+	}
+	if !reflect.DeepEqual(names, []string{"count-users", "list-users"}) {
+		t.Errorf("QueriesByPath(%q): got %v", users, names)
+	}
+
+	// embedded queries are addressed by their base name
+	if queries := s.QueriesByPath("get.sql"); len(queries) != 1 || queries[0].Name != "get-user" {
+		t.Errorf("QueriesByPath(get.sql): got %v", queries)
+	}
+	if queries := s.QueriesByPath("missing.sql"); len(queries) != 0 {
+		t.Errorf("QueriesByPath(missing.sql): got %v", queries)
+	}
+
+	if removed := s.RemoveByPath(users); removed != 2 {
+		t.Errorf("RemoveByPath(%q): got %d, expected 2", users, removed)
+	}
+	if removed := s.RemoveByPath("get.sql"); removed != 1 {
+		t.Errorf("RemoveByPath(get.sql): got %d, expected 1", removed)
+	}
+	if removed := s.RemoveByPath("get.sql"); removed != 0 {
+		t.Errorf("RemoveByPath(get.sql) again: got %d, expected 0", removed)
+	}
+
+	expected := []string{"list-items", "list-orders"}
+	if names := s.QueryNames(); !reflect.DeepEqual(names, expected) {
+		t.Errorf("QueryNames: got %v, expected %v", names, expected)
+	}
+}
+
+func TestWithDuplicatePolicy(t *testing.T) {
+	dir := t.TempDir()
+	first := writeSQLFile(t, dir, "first.sql", "-- name: get-user\nSELECT 1\n\n-- name: list-users\nSELECT * FROM users\n")
+	second := writeSQLFile(t, dir, "second.sql", "-- name: get-user\nSELECT 2\n\n-- name: count-users\nSELECT count(*) FROM users\n")
+
+	testCases := []struct {
+		name        string
+		policy      DuplicatePolicy
+		expectError bool
+		expectedRaw string
+		expectedLen int
+	}{
+		{name: "error", policy: PolicyError, expectError: true, expectedRaw: "SELECT 1", expectedLen: 2},
+		{name: "skip", policy: PolicySkip, expectedRaw: "SELECT 1", expectedLen: 3},
+		{name: "replace", policy: PolicyReplace, expectedRaw: "SELECT 2", expectedLen: 3},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			s := NewQueryStore(WithDuplicatePolicy(tc.policy))
+			if err := s.LoadFromFile(first); err != nil {
+				t.Fatal(err)
+			}
+
+			err := s.LoadFromFile(second)
+			if (err != nil) != tc.expectError {
+				t.Fatalf("LoadFromFile: got %v, expected error %v", err, tc.expectError)
+			}
+
+			if raw := s.MustHaveQuery("get-user").Raw; raw != tc.expectedRaw {
+				t.Errorf("get-user: got %q, expected %q", raw, tc.expectedRaw)
+			}
+			if s.Len() != tc.expectedLen {
+				t.Errorf("Len: got %d, expected %d", s.Len(), tc.expectedLen)
+			}
+		})
+	}
+}
+
+func TestMerge(t *testing.T) {
+	newStore := func(t *testing.T, content string, opts ...Option) *QueryStore {
+		t.Helper()
+		s := NewQueryStore(opts...)
+		if err := s.LoadFromString("queries.sql", content); err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+
+	t.Run("disjoint", func(t *testing.T) {
+		s := newStore(t, "-- name: get-user\nSELECT 1\n")
+		other := newStore(t, "-- name: list-items\nSELECT 2\n\n-- name: count-items\nSELECT 3\n")
+
+		if err := s.Merge(other); err != nil {
+			t.Fatal(err)
+		}
+
+		expected := []string{"count-items", "get-user", "list-items"}
+		if names := s.QueryNames(); !reflect.DeepEqual(names, expected) {
+			t.Errorf("QueryNames: got %v, expected %v", names, expected)
+		}
+		if other.Len() != 2 {
+			t.Errorf("other changed: %d queries", other.Len())
+		}
+	})
+
+	t.Run("overlapping with error policy", func(t *testing.T) {
+		s := newStore(t, "-- name: get-user\nSELECT 1\n")
+		other := newStore(t, "-- name: get-user\nSELECT 2\n\n-- name: list-items\nSELECT 3\n")
+
+		if err := s.Merge(other); err == nil {
+			t.Fatal("Merge: expected error for duplicate name")
+		}
+
+		if names := s.QueryNames(); !reflect.DeepEqual(names, []string{"get-user"}) {
+			t.Errorf("QueryNames: got %v, store was partially merged", names)
+		}
+		if raw := s.MustHaveQuery("get-user").Raw; raw != "SELECT 1" {
+			t.Errorf("get-user: got %q", raw)
+		}
+	})
+
+	t.Run("overlapping with replace policy", func(t *testing.T) {
+		s := newStore(t, "-- name: get-user\nSELECT 1\n", WithDuplicatePolicy(PolicyReplace))
+		other := newStore(t, "-- name: get-user\nSELECT 2\n\n-- name: list-items\nSELECT 3\n")
+
+		if err := s.Merge(other); err != nil {
+			t.Fatal(err)
+		}
+
+		if s.Len() != 2 {
+			t.Errorf("Len: got %d, expected 2", s.Len())
+		}
+		if raw := s.MustHaveQuery("get-user").Raw; raw != "SELECT 2" {
+			t.Errorf("get-user: got %q, expected %q", raw, "SELECT 2")
+		}
+	})
+}
+
+func BenchmarkNewQuery(b *testing.B) {
+	query := `SELECT u.id, u.email, to_char(u.created_at, 'YYYY-MM-DD HH24:MI:SS')
+FROM users u
+JOIN teams t ON t.id = u.team_id
+WHERE u.org_id = :org_id
+  AND u.status = :status
+  AND t.name = :team_name
+  AND u.created_at > :since::timestamptz
+  AND (u.owner_id = :user_id OR u.manager_id = :user_id)
+ORDER BY u.id
+LIMIT :limit OFFSET :offset`
+
+	for i := 0; i < b.N; i++ {
+		if _, err := ParseQuery("list-users", query); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestAliases(t *testing.T) {
+	s := NewQueryStore()
+	err := s.LoadFromString("users.sql", `-- name: get-user-by-id
+-- alias: get-user
+-- alias: fetch-user
+SELECT * FROM users WHERE id = :id
+
+-- name: list-users
+SELECT * FROM users
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"get-user-by-id", "get-user", "fetch-user"} {
+		q, err := s.Query(name)
+		if err != nil {
+			t.Errorf("Query(%q): %v", name, err)
+			continue
+		}
+		if q.Name != "get-user-by-id" {
+			t.Errorf("Query(%q): got %q", name, q.Name)
+		}
+		if !s.Has(name) {
+			t.Errorf("Has(%q): got false", name)
+		}
+	}
+
+	if names := s.QueryNames(); !reflect.DeepEqual(names, []string{"get-user-by-id", "list-users"}) {
+		t.Errorf("QueryNames: got %v", names)
+	}
+
+	// aliases go away with their query
+	s.Remove("get-user-by-id")
+	if s.Has("get-user") || s.Has("fetch-user") {
+		t.Errorf("aliases of a removed query still resolve")
+	}
+}
+
+func TestAliasCollisions(t *testing.T) {
+	testCases := []struct {
+		name     string
+		loaded   string
+		incoming string
+	}{
+		{
+			name:     "alias of a loaded name",
+			loaded:   "-- name: get-user\nSELECT 1\n",
+			incoming: "-- name: get-user-by-id\n-- alias: get-user\nSELECT 2\n",
+		},
+		{
+			name:     "name of a loaded alias",
+			loaded:   "-- name: get-user-by-id\n-- alias: get-user\nSELECT 1\n",
+			incoming: "-- name: get-user\nSELECT 2\n",
+		},
+		{
+			name:     "alias of a loaded alias",
+			loaded:   "-- name: get-user-by-id\n-- alias: get-user\nSELECT 1\n",
+			incoming: "-- name: get-user-by-email\n-- alias: get-user\nSELECT 2\n",
+		},
+		{
+			name:     "alias of a name in the same file",
+			incoming: "-- name: get-user\nSELECT 1\n\n-- name: get-user-by-id\n-- alias: get-user\nSELECT 2\n",
+		},
+		{
+			name:     "alias shared in the same file",
+			incoming: "-- name: get-user-by-id\n-- alias: get-user\nSELECT 1\n\n-- name: get-user-by-email\n-- alias: get-user\nSELECT 2\n",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			s := NewQueryStore()
+			if tc.loaded != "" {
+				if err := s.LoadFromString("loaded.sql", tc.loaded); err != nil {
+					t.Fatal(err)
+				}
+			}
+			before := s.QueryNames()
+
+			if err := s.LoadFromString("incoming.sql", tc.incoming); err == nil {
+				t.Fatalf("LoadFromString: expected collision error")
+			}
+			if names := s.QueryNames(); !reflect.DeepEqual(names, before) {
+				t.Errorf("QueryNames: got %v, expected %v", names, before)
+			}
+		})
+	}
+}
+
+func TestLoadFromGlob(t *testing.T) {
+	dir := t.TempDir()
+	writeSQLFile(t, dir, "users/queries/get.sql", "-- name: get-user\nSELECT 1\n")
+	writeSQLFile(t, dir, "users/queries/notes.txt", "-- name: not-sql\nSELECT 2\n")
+	writeSQLFile(t, dir, "orders/queries/list.sql", "-- name: list-orders\nSELECT 3\n")
+	writeSQLFile(t, dir, "orders/migrations/001.sql", "-- name: create-orders\nCREATE TABLE orders ()\n")
+
+	s := NewQueryStore()
+	if err := s.LoadFromGlob(filepath.Join(dir, "*/queries/*")); err != nil {
+		t.Fatalf("LoadFromGlob: %v", err)
+	}
+
+	expected := []string{"get-user", "list-orders"}
+	if names := s.QueryNames(); !reflect.DeepEqual(names, expected) {
+		t.Errorf("QueryNames: got %v, expected %v", names, expected)
+	}
+
+	if err := NewQueryStore().LoadFromGlob(filepath.Join(dir, "[")); err == nil {
+		t.Errorf("LoadFromGlob: expected error for malformed pattern")
+	}
+	if err := NewQueryStore(WithMinQueries(1)).LoadFromGlob(filepath.Join(dir, "*/missing/*.sql")); err == nil {
+		t.Errorf("LoadFromGlob: expected WithMinQueries to reject a pattern without matches")
+	}
+}
+
+func TestLoadFromFiles(t *testing.T) {
+	dir := t.TempDir()
+	users := writeSQLFile(t, dir, "users.sql", "-- name: get-user\nSELECT 1\n")
+	orders := writeSQLFile(t, dir, "orders.sql", "-- name: list-orders\nSELECT 2\n")
+	broken := writeSQLFile(t, dir, "broken.sql", "-- name: broken\nSELECT :a, $1\n")
+	items := writeSQLFile(t, dir, "items.sql", "-- name: list-items\nSELECT 3\n")
+
+	s := NewQueryStore()
+	if err := s.LoadFromFiles(users, orders); err != nil {
+		t.Fatalf("LoadFromFiles: %v", err)
+	}
+	if names := s.QueryNames(); !reflect.DeepEqual(names, []string{"get-user", "list-orders"}) {
+		t.Errorf("QueryNames: got %v", names)
+	}
+
+	s = NewQueryStore()
+	err := s.LoadFromFiles(users, broken, items)
+	if err == nil || !strings.Contains(err.Error(), "'"+broken+"'") {
+		t.Fatalf("LoadFromFiles: got %v, expected error naming %s", err, broken)
+	}
+	if names := s.QueryNames(); !reflect.DeepEqual(names, []string{"get-user"}) {
+		t.Errorf("QueryNames after failure: got %v", names)
+	}
+
+	if err := NewQueryStore().LoadFromFiles(filepath.Join(dir, "missing.sql")); err == nil {
+		t.Errorf("LoadFromFiles: expected error for missing file")
+	}
+}
+
+func TestWithNamespaceFromDir(t *testing.T) {
+	dir := t.TempDir()
+	writeSQLFile(t, dir, "users/queries.sql", "-- name: list\n-- alias: all\nSELECT * FROM users\n")
+	writeSQLFile(t, dir, "orders/queries.sql", "-- name: list\nSELECT * FROM orders\n")
+	writeSQLFile(t, dir, "orders/archive/queries.sql", "-- name: list\nSELECT * FROM orders_archive\n")
+	writeSQLFile(t, dir, "health.sql", "-- name: ping\nSELECT 1\n")
+
+	testCases := []struct {
+		name          string
+		opts          []Option
+		expectedNames []string
+	}{
+		{
+			name:          "default separator",
+			opts:          []Option{WithNamespaceFromDir(true)},
+			expectedNames: []string{"orders/archive/list", "orders/list", "ping", "users/list"},
+		},
+		{
+			name:          "custom separator",
+			opts:          []Option{WithNamespaceFromDir(true), WithNamespaceSeparator(".")},
+			expectedNames: []string{"orders.archive.list", "orders.list", "ping", "users.list"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			s := NewQueryStore(tc.opts...)
+			if err := s.LoadFromDir(dir); err != nil {
+				t.Fatalf("LoadFromDir: %v", err)
+			}
+
+			if names := s.QueryNames(); !reflect.DeepEqual(names, tc.expectedNames) {
+				t.Errorf("QueryNames: got %v, expected %v", names, tc.expectedNames)
+			}
+		})
+	}
+
+	s := NewQueryStore(WithNamespaceFromDir(true))
+	if err := s.LoadFromDir(dir); err != nil {
+		t.Fatal(err)
+	}
+	q, err := s.Query("users/list")
+	if err != nil {
+		t.Fatalf("Query(users/list): %v", err)
+	}
+	if q.Name != "users/list" || q.SQL() != "SELECT * FROM users" {
+		t.Errorf("Query(users/list): got %q %q", q.Name, q.SQL())
+	}
+	if alias, err := s.Query("users/all"); err != nil || alias != q {
+		t.Errorf("Query(users/all): got %v, %v", alias, err)
+	}
+
+	// without the option the names collide
+	if err := NewQueryStore().LoadFromDir(dir); err == nil {
+		t.Errorf("LoadFromDir without namespaces: expected duplicate error")
+	}
+
+	fsys := fstest.MapFS{
+		"sql/users/get.sql":  {Data: []byte("-- name: get\nSELECT 1\n")},
+		"sql/orders/get.sql": {Data: []byte("-- name: get\nSELECT 2\n")},
+	}
+	s = NewQueryStore(WithNamespaceFromDir(true))
+	if err := s.LoadFromFS(fsys, "sql"); err != nil {
+		t.Fatalf("LoadFromFS: %v", err)
+	}
+	if names := s.QueryNames(); !reflect.DeepEqual(names, []string{"orders/get", "users/get"}) {
+		t.Errorf("LoadFromFS QueryNames: got %v", names)
+	}
+}
+
+func TestPrepareStrictParamTypes(t *testing.T) {
+	s := NewQueryStore()
+	content := "-- name: find-users\n-- param: id int\n-- param: email varchar(255)\n-- param: active bool\n-- param: since timestamptz\n-- param: tags text[]\n-- param: settings jsonb\n" +
+		"SELECT * FROM users WHERE id = :id AND email = :email AND active = :active AND created_at > :since AND tags && :tags AND settings @> :settings\n"
+	if err := s.LoadFromString("users.sql", content); err != nil {
+		t.Fatalf("LoadFromString: %v", err)
+	}
+	q := s.MustHaveQuery("find-users")
+
+	valid := func() map[string]interface{} {
+		return map[string]interface{}{
+			"id":       int64(1),
+			"email":    "a@example.com",
+			"active":   true,
+			"since":    time.Now(),
+			"tags":     []string{"admin"},
+			"settings": `{"beta": true}`,
+		}
+	}
+
+	testCases := []struct {
+		name          string
+		change        map[string]interface{}
+		expectedError string
+	}{
+		{name: "valid"},
+		{name: "null", change: map[string]interface{}{"id": nil, "email": (*string)(nil)}},
+		{name: "valuer", change: map[string]interface{}{"id": sql.NullInt64{Int64: 1, Valid: true}}},
+		{
+			name:          "string for int",
+			change:        map[string]interface{}{"id": "1"},
+			expectedError: "Query 'find-users': argument 'id' is string, expected int",
+		},
+		{
+			name:          "several mismatches",
+			change:        map[string]interface{}{"active": 1, "since": "2024-01-01", "tags": "admin"},
+			expectedError: "Query 'find-users': argument 'active' is int, expected bool; argument 'since' is string, expected timestamptz; argument 'tags' is string, expected text[]",
+		},
+		{
+			name:          "array element",
+			change:        map[string]interface{}{"tags": []int{1}},
+			expectedError: "Query 'find-users': argument 'tags' is []int, expected text[]",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			args := valid()
+			for k, v := range tc.change {
+				args[k] = v
+			}
+
+			_, err := q.PrepareStrict(args)
+			if tc.expectedError == "" {
+				if err != nil {
+					t.Errorf("PrepareStrict: %v", err)
+				}
+				return
+			}
+			if err == nil || err.Error() != tc.expectedError {
+				t.Errorf("PrepareStrict: got error %v, expected %q", err, tc.expectedError)
+			}
+		})
+	}
+
+	// Prepare doesn't check the annotations
+	if args := q.Prepare(map[string]interface{}{"id": "1"}); args[0] != "1" {
+		t.Errorf("Prepare: got %v", args)
+	}
+}
+
+func TestWithStrictParams(t *testing.T) {
+	testCases := []struct {
+		name          string
+		content       string
+		expectedError string
+	}{
+		{
+			name:    "declared and used",
+			content: "-- name: get-user\n-- param: id int\nSELECT * FROM users WHERE id = :id\n",
+		},
+		{
+			name:    "used but undeclared",
+			content: "-- name: get-user\n-- param: id int\nSELECT * FROM users WHERE id = :id AND status = :status\n",
+		},
+		{
+			name:          "declared but unused",
+			content:       "-- name: get-user\n-- param: user_id int\n-- param: id int\n-- param: email text\nSELECT * FROM users WHERE id = :id -- AND email = :email\n",
+			expectedError: "Query 'get-user' declares unused parameters: email, user_id",
+		},
+		{
+			name:    "used in an include",
+			content: "-- name: filter\nWHERE id = :id\n\n-- name: get-user\n-- param: id int\nSELECT * FROM users\n-- include: filter\n",
+		},
+		{
+			name:          "unused after include",
+			content:       "-- name: filter\nWHERE id = :id\n\n-- name: get-user\n-- param: email text\nSELECT * FROM users\n-- include: filter\n",
+			expectedError: "Query 'get-user' declares unused parameters: email",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := NewQueryStore(WithStrictParams(true)).LoadFromString("users.sql", tc.content)
+			if tc.expectedError == "" {
+				if err != nil {
+					t.Errorf("LoadFromString: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tc.expectedError) {
+				t.Errorf("LoadFromString: got %v, expected %q", err, tc.expectedError)
+			}
+
+			if err := NewQueryStore().LoadFromString("users.sql", tc.content); err != nil {
+				t.Errorf("LoadFromString without WithStrictParams: %v", err)
+			}
+		})
+	}
+}
+
+func TestParamCount(t *testing.T) {
+	testCases := []struct {
+		name             string
+		query            string
+		opts             options
+		expectedDistinct int
+		expectedTotal    int
+	}{
+		{name: "none", query: "SELECT * FROM users", expectedDistinct: 0, expectedTotal: 0},
+		{name: "distinct", query: "SELECT * FROM users WHERE id = :id AND name = :name", expectedDistinct: 2, expectedTotal: 2},
+		{name: "repeated", query: "SELECT * FROM t WHERE x = :a AND y = :a AND z = :b", expectedDistinct: 2, expectedTotal: 3},
+		{name: "expanded", query: "SELECT * FROM t WHERE x = :a AND y = :a AND z = :b", opts: options{expandRepeatedParams: true}, expectedDistinct: 2, expectedTotal: 3},
+		{name: "positional", query: "SELECT * FROM t WHERE x = $1 AND y = $1 AND z = $2", expectedDistinct: 2, expectedTotal: 3},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			distinct, total := mustNewQueryWith(t, tc.name, tc.query, tc.opts).ParamCount()
+			if distinct != tc.expectedDistinct || total != tc.expectedTotal {
+				t.Errorf("ParamCount: got %d, %d, expected %d, %d", distinct, total, tc.expectedDistinct, tc.expectedTotal)
+			}
+		})
+	}
+}
+
+func TestParamNames(t *testing.T) {
+	testCases := []struct {
+		name     string
+		query    string
+		opts     options
+		expected []string
+	}{
+		{name: "none", query: "SELECT * FROM users", expected: []string{}},
+		{name: "named", query: "SELECT * FROM t WHERE z = :zeta AND x = :alpha AND y = :zeta AND w = :mid", expected: []string{"zeta", "alpha", "mid"}},
+		{name: "expanded", query: "SELECT * FROM t WHERE z = :zeta AND y = :zeta AND x = :alpha", opts: options{expandRepeatedParams: true}, expected: []string{"zeta", "alpha"}},
+		{name: "positional", query: "SELECT * FROM t WHERE x = $2 AND y = $1", expected: []string{"arg1", "arg2"}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			q := mustNewQueryWith(t, tc.name, tc.query, tc.opts)
+
+			names := q.ParamNames()
+			if !reflect.DeepEqual(names, tc.expected) {
+				t.Errorf("ParamNames: got %v, expected %v", names, tc.expected)
+			}
+			for i := 1; i < len(names); i++ {
+				if q.Mapping[names[i-1]] >= q.Mapping[names[i]] {
+					t.Errorf("ParamNames: %s ($%d) listed before %s ($%d)", names[i-1], q.Mapping[names[i-1]], names[i], q.Mapping[names[i]])
+				}
+			}
+		})
+	}
+}
+
+func TestQueriesReturnsCopies(t *testing.T) {
+	s := NewQueryStore()
+	content := "-- name: get-user\n-- alias: user\n-- description: Fetch a user\n-- param: id int\nSELECT * FROM users WHERE id = :id AND status = :status\n"
+	if err := s.LoadFromString("users.sql", content); err != nil {
+		t.Fatalf("LoadFromString: %v", err)
+	}
+	original := s.MustHaveQuery("get-user").Clone()
+
+	q := s.Queries()["get-user"]
+	if !reflect.DeepEqual(q, original) {
+		t.Fatalf("Queries: got %+v, expected %+v", q, original)
+	}
+
+	q.Metadata["description"] = "changed"
+	q.Metadata["timeout"] = "1s"
+	q.MetadataOriginal()["description"] = "changed"
+	q.Mapping["id"] = 7
+	q.Args[0] = "changed"
+	q.NamedArgs[0].Value = 1
+	q.Aliases[0] = "changed"
+	q.ParamTypes["id"] = "text"
+
+	if stored := s.MustHaveQuery("get-user"); !reflect.DeepEqual(stored, original) {
+		t.Errorf("store changed through Queries: got %+v, expected %+v", stored, original)
+	}
+	if !s.Has("user") {
+		t.Errorf("alias lost")
+	}
+}
+
+func TestForEach(t *testing.T) {
+	s := NewQueryStore()
+	content := "-- name: c\nSELECT 3\n\n-- name: a\nSELECT 1\n\n-- name: d\nSELECT 4\n\n-- name: b\nSELECT 2\n"
+	if err := s.LoadFromString("queries.sql", content); err != nil {
+		t.Fatalf("LoadFromString: %v", err)
+	}
+
+	collect := func(limit int) []string {
+		var names []string
+		s.ForEach(func(name string, q *Query) bool {
+			if q.Name != name {
+				t.Errorf("ForEach: got query %s for name %s", q.Name, name)
+			}
+			names = append(names, name)
+			return len(names) < limit
+		})
+		return names
+	}
+
+	if names := collect(10); !reflect.DeepEqual(names, []string{"a", "b", "c", "d"}) {
+		t.Errorf("ForEach: got %v", names)
+	}
+	if names := collect(2); !reflect.DeepEqual(names, []string{"a", "b"}) {
+		t.Errorf("ForEach stopping early: got %v", names)
+	}
+
+	for i := 0; i < 5; i++ {
+		if names := collect(10); !reflect.DeepEqual(names, s.QueryNames()) {
+			t.Fatalf("ForEach order changed: got %v", names)
+		}
+	}
+}
+
+func TestFilter(t *testing.T) {
+	s := NewQueryStore()
+	content := "-- name: list-users\n-- tags: cacheable, users\n-- max-cost: 100\nSELECT * FROM users\n\n" +
+		"-- name: get-user\n-- tags: users\nSELECT * FROM users WHERE id = :id\n\n" +
+		"-- name: list-orders\n-- tags: cacheable\nSELECT * FROM orders\n\n" +
+		"-- name: report\n-- max-cost: 5000\nSELECT count(*) FROM orders\n"
+	if err := s.LoadFromString("queries.sql", content); err != nil {
+		t.Fatalf("LoadFromString: %v", err)
+	}
+
+	names := func(queries []*Query) []string {
+		var names []string
+		for _, q := range queries {
+			names = append(names, q.Name)
+		}
+		return names
+	}
+
+	cacheable := s.Filter(func(q *Query) bool {
+		tags, _ := q.GetMetadataList("tags")
+		for _, tag := range tags {
+			if tag == "cacheable" {
+				return true
+			}
+		}
+		return false
+	})
+	if got := names(cacheable); !reflect.DeepEqual(got, []string{"list-orders", "list-users"}) {
+		t.Errorf("Filter by tag: got %v", got)
+	}
+
+	budgeted := s.Filter(func(q *Query) bool {
+		_, ok := q.GetMetadata("max-cost")
+		return ok
+	})
+	if got := names(budgeted); !reflect.DeepEqual(got, []string{"list-users", "report"}) {
+		t.Errorf("Filter by max-cost: got %v", got)
+	}
+
+	if none := s.Filter(func(*Query) bool { return false }); len(none) != 0 {
+		t.Errorf("Filter: got %v", names(none))
+	}
+}
+
+func TestFilterWhileWriting(t *testing.T) {
+	readWhileWriting(t, func(s *QueryStore) {
+		s.Filter(func(q *Query) bool { return true })
+	})
+}
+
+func TestWithAtSignParams(t *testing.T) {
+	query := "SELECT * FROM users WHERE id = @user_id OR parent_id = @user_id AND status = @status AND tags @> '{a}' AND email <> 'a@example.com'"
+
+	q := mustNewQueryWith(t, "get-user", query, options{atSignParams: true})
+
+	expectedOrd := "-- name: get-user\nSELECT * FROM users WHERE id = $1 OR parent_id = $1 AND status = $2 AND tags @> '{a}' AND email <> 'a@example.com'"
+	if q.OrdinalQuery != expectedOrd {
+		t.Errorf("OrdinalQuery: got %q, expected %q", q.OrdinalQuery, expectedOrd)
+	}
+	if !reflect.DeepEqual(q.Args, []string{"user_id", "user_id", "status"}) {
+		t.Errorf("Args: got %v", q.Args)
+	}
+	if !reflect.DeepEqual(q.Mapping, map[string]int{"user_id": 1, "status": 2}) {
+		t.Errorf("Mapping: got %v", q.Mapping)
+	}
+	if args := q.Prepare(map[string]interface{}{"user_id": 7, "status": "active"}); !reflect.DeepEqual(args, []interface{}{7, "active"}) {
+		t.Errorf("Prepare: got %v", args)
+	}
+
+	// without the option "@" is left alone
+	plain := mustNewQueryWith(t, "get-user", query, options{})
+	if len(plain.Args) != 0 || !strings.Contains(plain.OrdinalQuery, "@user_id") {
+		t.Errorf("@ detected without WithAtSignParams: %v", plain.Args)
+	}
+
+	s := NewQueryStore(WithAtSignParams(true))
+	if err := s.LoadFromString("users.sql", "-- name: get-user\nSELECT * FROM users WHERE id = @id\n"); err != nil {
+		t.Fatalf("LoadFromString: %v", err)
+	}
+	if sql := s.MustHaveQuery("get-user").SQL(); sql != "SELECT * FROM users WHERE id = $1" {
+		t.Errorf("SQL: got %q", sql)
+	}
+}
+
+func TestParamAtStartOfQuery(t *testing.T) {
+	testCases := []struct {
+		name         string
+		query        string
+		opts         options
+		expectedSQL  string
+		expectedArgs []string
+	}{
+		{name: "named", query: ":id = 5", expectedSQL: "$1 = 5", expectedArgs: []string{"id"}},
+		{name: "quoted", query: ":'id' = 5", expectedSQL: "$1 = 5", expectedArgs: []string{"id"}},
+		{name: "after comment", query: "-- filter\n:id = 5 AND :status IS NULL", expectedSQL: "-- filter\n$1 = 5 AND $2 IS NULL", expectedArgs: []string{"id", "status"}},
+		{name: "block comment", query: "/* x */:id = 5", expectedSQL: "/* x */$1 = 5", expectedArgs: []string{"id"}},
+		{name: "at sign", query: "@id = 5", opts: options{atSignParams: true}, expectedSQL: "$1 = 5", expectedArgs: []string{"id"}},
+		{name: "cast", query: "::int", expectedSQL: "::int", expectedArgs: nil},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			q := mustNewQueryWith(t, tc.name, tc.query, tc.opts)
+			if sql := q.SQL(); sql != tc.expectedSQL {
+				t.Errorf("SQL: got %q, expected %q", sql, tc.expectedSQL)
+			}
+			if !reflect.DeepEqual(q.Args, tc.expectedArgs) {
+				t.Errorf("Args: got %v, expected %v", q.Args, tc.expectedArgs)
+			}
+		})
+	}
+
+	s := NewQueryStore()
+	if err := s.LoadFromString("filters.sql", "-- name: by-id\n:id = user_id\n"); err != nil {
+		t.Fatalf("LoadFromString: %v", err)
+	}
+	if q := s.MustHaveQuery("by-id"); !reflect.DeepEqual(q.Args, []string{"id"}) {
+		t.Errorf("Args of loaded query: got %v", q.Args)
+	}
+}
+
+func TestAtSignParamsSkipMySQLVariables(t *testing.T) {
+	testCases := []struct {
+		name         string
+		query        string
+		expectedSQL  string
+		expectedArgs []string
+	}{
+		{
+			name:         "system variable",
+			query:        "SELECT @@global.max_connections, @@session.sql_mode WHERE id = @id",
+			expectedSQL:  "SELECT @@global.max_connections, @@session.sql_mode WHERE id = $1",
+			expectedArgs: []string{"id"},
+		},
+		{
+			name:        "set user variable",
+			query:       "SET @x = 1",
+			expectedSQL: "SET @x = 1",
+		},
+		{
+			name:         "counter",
+			query:        "SET @counter = @counter + 1;\nSELECT @counter FROM t WHERE id = @id",
+			expectedSQL:  "SET @counter = @counter + 1;\nSELECT @counter FROM t WHERE id = $1",
+			expectedArgs: []string{"id"},
+		},
+		{
+			name:         "inline assignment",
+			query:        "SELECT @rank := @rank + 1 AS rank, name FROM users WHERE org = @org",
+			expectedSQL:  "SELECT @rank := @rank + 1 AS rank, name FROM users WHERE org = $1",
+			expectedArgs: []string{"org"},
+		},
+		{
+			name:         "select into",
+			query:        "SELECT count(*) INTO @total FROM users WHERE org = @org;\nSELECT @total",
+			expectedSQL:  "SELECT count(*) INTO @total FROM users WHERE org = $1;\nSELECT @total",
+			expectedArgs: []string{"org"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			q := mustNewQueryWith(t, tc.name, tc.query, options{atSignParams: true})
+			if sql := q.SQL(); sql != tc.expectedSQL {
+				t.Errorf("SQL: got %q, expected %q", sql, tc.expectedSQL)
+			}
+			if !reflect.DeepEqual(q.Args, tc.expectedArgs) {
+				t.Errorf("Args: got %v, expected %v", q.Args, tc.expectedArgs)
+			}
+		})
+	}
+}
+
+func TestQueryStyle(t *testing.T) {
+	testCases := []struct {
+		name     string
+		query    string
+		opts     options
+		expected ParamStyle
+	}{
+		{name: "none", query: "SELECT * FROM users", expected: StyleNone},
+		{name: "colon", query: "SELECT * FROM users WHERE id = :id", expected: StyleColon},
+		{name: "quoted colon", query: "SELECT * FROM users WHERE id = :'id'", expected: StyleColon},
+		{name: "positional", query: "SELECT * FROM users WHERE id = $1", expected: StylePositional},
+		{name: "at sign", query: "SELECT * FROM users WHERE id = @id", opts: options{atSignParams: true}, expected: StyleAtSign},
+		{name: "at sign disabled", query: "SELECT * FROM users WHERE id = @id", expected: StyleNone},
+		{name: "question mark", query: "SELECT * FROM users WHERE id = ?", opts: options{questionMarkParams: true}, expected: StyleQuestionMark},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if style := mustNewQueryWith(t, tc.name, tc.query, tc.opts).Style; style != tc.expected {
+				t.Errorf("Style: got %v, expected %v", style, tc.expected)
+			}
+		})
+	}
+
+	if s := ParamStyle(42).String(); s != "ParamStyle(42)" {
+		t.Errorf("String: got %q", s)
+	}
+}
+
+func TestNamedQuery(t *testing.T) {
+	query := "SELECT * FROM users WHERE id = :user_id AND status = :status"
+
+	q := mustNewQuery(t, "get-user", query)
+	if named := q.NamedQuery(); named != "-- name: get-user\n"+query {
+		t.Errorf("NamedQuery: got %q", named)
+	}
+	if ordinal := q.Query(); ordinal != "-- name: get-user\nSELECT * FROM users WHERE id = $1 AND status = $2" {
+		t.Errorf("OrdinalQuery: got %q", ordinal)
+	}
+
+	// both share the header
+	q = mustNewQueryWith(t, "get-user", query, options{paramsInHeader: true})
+	namedHeader, _, _ := strings.Cut(q.NamedQuery(), "\n")
+	ordinalHeader, _, _ := strings.Cut(q.OrdinalQuery, "\n")
+	if namedHeader != ordinalHeader || namedHeader != "-- name: get-user (user_id, status)" {
+		t.Errorf("headers: got %q and %q", namedHeader, ordinalHeader)
+	}
+
+	literal := &Query{Name: "literal", Raw: "SELECT 1"}
+	if named := literal.NamedQuery(); named != "-- name: literal\nSELECT 1" {
+		t.Errorf("NamedQuery of a literal query: got %q", named)
+	}
+}
+
+func TestNewQueryDollarQuotedBodies(t *testing.T) {
+	testCases := []struct {
+		name         string
+		query        string
+		opts         options
+		expectedArgs []string
+	}{
+		{
+			name:  "function body",
+			query: "CREATE FUNCTION add_one(integer) RETURNS integer AS $$ BEGIN RETURN $1 + 1; END; $$ LANGUAGE plpgsql",
+		},
+		{
+			name:  "tagged body",
+			query: "CREATE FUNCTION f(a int, b int) RETURNS int AS $body$ SELECT $1 + $2 -- it's $3\n $body$ LANGUAGE sql",
+		},
+		{
+			name:  "named inside body",
+			query: "DO $$ DECLARE x int := 1; BEGIN PERFORM :not_a_param; END $$",
+		},
+		{
+			name:         "positional outside body",
+			query:        "SELECT $1::int, $$ costs $2 $$",
+			expectedArgs: []string{"arg1"},
+		},
+		{
+			name:         "named outside body",
+			query:        "SELECT format($fmt$%s: $1$fmt$, :name)",
+			expectedArgs: []string{"name"},
+		},
+		{
+			name:         "dollar signs in a literal",
+			query:        "SELECT '$$' || :name || '$$'",
+			expectedArgs: []string{"name"},
+		},
+		{
+			name:         "question mark inside body",
+			query:        "SELECT $$ why? $$, ?",
+			opts:         options{questionMarkParams: true},
+			expectedArgs: []string{"arg1"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			q := mustNewQueryWith(t, tc.name, tc.query, tc.opts)
+			if !reflect.DeepEqual(q.Args, tc.expectedArgs) {
+				t.Errorf("Args: got %v, expected %v", q.Args, tc.expectedArgs)
+			}
+			if q.Raw != tc.query {
+				t.Errorf("Raw changed: %q", q.Raw)
+			}
+		})
+	}
+}
+
+func TestLoadFromDirContext(t *testing.T) {
+	dir := t.TempDir()
+	for i := 0; i < 20; i++ {
+		writeSQLFile(t, dir, fmt.Sprintf("q%02d.sql", i), fmt.Sprintf("-- name: q%02d\nSELECT %d\n", i, i))
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// cancel while the third file is loaded
+	loaded := 0
+	s := NewQueryStore(WithPreprocessor(func(path, sql string) (string, error) {
+		if loaded++; loaded == 3 {
+			cancel()
+		}
+		return sql, nil
+	}))
+
+	err := s.LoadFromDirContext(ctx, dir)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("LoadFromDirContext: got %v, expected context.Canceled", err)
+	}
+	if s.Len() != 3 {
+		t.Errorf("loaded %d queries before cancellation, expected 3", s.Len())
+	}
+
+	if err := NewQueryStore().LoadFromDirContext(context.Background(), dir); err != nil {
+		t.Errorf("LoadFromDirContext: %v", err)
+	}
+}
+
+func TestWithEnvExpansion(t *testing.T) {
+	content := "-- name: get-user\nSELECT * FROM ${SCHEMA}.users WHERE id = :id AND org = $$${SCHEMA}$$\n"
+
+	s := NewQueryStore(WithEnvExpansion(map[string]string{"SCHEMA": "myapp_dev"}))
+	if err := s.LoadFromString("users.sql", content); err != nil {
+		t.Fatalf("LoadFromString: %v", err)
+	}
+
+	q := s.MustHaveQuery("get-user")
+	if expected := "SELECT * FROM myapp_dev.users WHERE id = :id AND org = $$myapp_dev$$"; q.Raw != expected {
+		t.Errorf("Raw: got %q, expected %q", q.Raw, expected)
+	}
+	if expected := "SELECT * FROM myapp_dev.users WHERE id = $1 AND org = $$myapp_dev$$"; q.SQL() != expected {
+		t.Errorf("SQL: got %q, expected %q", q.SQL(), expected)
+	}
+	if !reflect.DeepEqual(q.Args, []string{"id"}) {
+		t.Errorf("Args: got %v", q.Args)
+	}
+
+	t.Setenv("QUERIES_TEST_SCHEMA", "myapp_prod")
+	s = NewQueryStore(WithEnvExpansion(nil))
+	if err := s.LoadFromString("orders.sql", "-- name: get-order\nSELECT * FROM ${QUERIES_TEST_SCHEMA}.orders WHERE id = $1\n"); err != nil {
+		t.Fatalf("LoadFromString: %v", err)
+	}
+	if sql := s.MustHaveQuery("get-order").SQL(); sql != "SELECT * FROM myapp_prod.orders WHERE id = $1" {
+		t.Errorf("SQL: got %q", sql)
+	}
+
+	err := NewQueryStore(WithEnvExpansion(map[string]string{})).LoadFromString("users.sql", content)
+	if err == nil || !strings.HasSuffix(err.Error(), "undefined variables: SCHEMA") {
+		t.Errorf("LoadFromString with undefined variable: got %v", err)
+	}
+
+	// off by default
+	if err := NewQueryStore().LoadFromString("users.sql", content); err != nil {
+		t.Errorf("LoadFromString without WithEnvExpansion: %v", err)
+	}
+}
+
+func TestLoadLongLine(t *testing.T) {
+	ids := make([]string, 20000)
+	for i := range ids {
+		ids[i] = fmt.Sprint(i)
+	}
+	body := "SELECT * FROM users WHERE id IN (" + strings.Join(ids, ", ") + ") AND org_id = :org_id"
+	if len(body) <= 64*1024 {
+		t.Fatalf("query is only %d bytes", len(body))
+	}
+
+	path := writeSQLFile(t, t.TempDir(), "users.sql", "-- name: list-users\n"+body+"\n\n-- name: get-user\nSELECT * FROM users WHERE id = :id\n")
+
+	s := NewQueryStore()
+	if err := s.LoadFromFile(path); err != nil {
+		t.Fatalf("LoadFromFile: %v", err)
+	}
+
+	if q := s.MustHaveQuery("list-users"); q.Raw != body || !reflect.DeepEqual(q.Args, []string{"org_id"}) {
+		t.Errorf("long query not loaded intact: %d bytes, args %v", len(q.Raw), q.Args)
+	}
+	if !s.Has("get-user") {
+		t.Errorf("query after the long line not loaded")
+	}
+}
+
+func TestWithIgnore(t *testing.T) {
+	dir := t.TempDir()
+	writeSQLFile(t, dir, "users.sql", "-- name: get-user\nSELECT 1\n")
+	writeSQLFile(t, dir, "migrations/001.up.sql", "-- name: create-users\nCREATE TABLE users ()\n")
+	writeSQLFile(t, dir, "migrations/001.down.sql", "-- name: drop-users\nDROP TABLE users\n")
+	writeSQLFile(t, dir, "fixtures/users_test.sql", "-- name: seed-users\nINSERT INTO users DEFAULT VALUES\n")
+
+	ignore := WithIgnore("*.down.sql", "*_test.sql", "[")
+	expected := []string{"create-users", "get-user"}
+
+	testCases := []struct {
+		name string
+		opts []Option
+		load func(s *QueryStore) error
+	}{
+		{name: "dir", load: func(s *QueryStore) error { return s.LoadFromDir(dir) }},
+		{name: "parallel", opts: []Option{WithParallelism(4)}, load: func(s *QueryStore) error { return s.LoadFromDir(dir) }},
+		{name: "fs", load: func(s *QueryStore) error { return s.LoadFromFS(os.DirFS(dir), ".") }},
+		{name: "glob", load: func(s *QueryStore) error {
+			if err := s.LoadFromGlob(filepath.Join(dir, "*.sql")); err != nil {
+				return err
+			}
+			return s.LoadFromGlob(filepath.Join(dir, "*", "*.sql"))
+		}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			s := NewQueryStore(append(tc.opts, ignore)...)
+			if err := tc.load(s); err != nil {
+				t.Fatalf("load: %v", err)
+			}
+
+			if names := s.QueryNames(); !reflect.DeepEqual(names, expected) {
+				t.Errorf("QueryNames: got %v, expected %v", names, expected)
+			}
+		})
+	}
+
+	s := NewQueryStore()
+	if err := s.LoadFromDir(dir); err != nil {
+		t.Fatalf("LoadFromDir: %v", err)
+	}
+	if s.Len() != 4 {
+		t.Errorf("loaded %d queries without WithIgnore, expected 4", s.Len())
+	}
+}
+
+func TestQueryOr(t *testing.T) {
+	s := NewQueryStore()
+	if err := s.LoadFromString("users.sql", "-- name: get-user\n-- alias: user-by-id\nSELECT * FROM users WHERE id = :id\n"); err != nil {
+		t.Fatalf("LoadFromString: %v", err)
+	}
+	fallback := mustNewQuery(t, "fallback", "SELECT NULL")
+	getUser := s.MustHaveQuery("get-user")
+
+	if q := s.QueryOr("get-user", fallback); q != getUser {
+		t.Errorf("QueryOr by name: got %v", q)
+	}
+	if q := s.QueryOr("user-by-id", fallback); q != getUser {
+		t.Errorf("QueryOr by alias: got %v", q)
+	}
+	if q := s.QueryOr("missing", fallback); q != fallback {
+		t.Errorf("QueryOr for a missing query: got %v, expected the fallback", q)
+	}
+	if q := s.QueryOr("missing", nil); q != nil {
+		t.Errorf("QueryOr with a nil fallback: got %v", q)
+	}
+}
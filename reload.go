@@ -0,0 +1,41 @@
+package queries
+
+// loadSource repeats a load call on the given store
+type loadSource func(*QueryStore) error
+
+func (s *QueryStore) addSource(load loadSource) {
+	s.mu.Lock()
+	s.sources = append(s.sources, load)
+	s.mu.Unlock()
+}
+
+// Reload loads the files, directories, globs and file systems passed to
+// the Load* methods again, in the same order, and replaces the loaded
+// queries with the result. Queries from LoadFromReader, LoadFromString,
+// LoadFromJSON and Merge can't be loaded again and are dropped. When a
+// source fails to load the store is left unchanged.
+func (s *QueryStore) Reload() error {
+	s.mu.RLock()
+	sources := append([]loadSource{}, s.sources...)
+	s.mu.RUnlock()
+
+	fresh := NewQueryStore()
+	fresh.opts = s.opts
+	for _, load := range sources {
+		if err := load(fresh); err != nil {
+			return err
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.Close()
+
+	s.queries = fresh.queries
+	s.aliases = fresh.aliases
+	s.warnings = fresh.warnings
+	s.invalidateNames()
+
+	return nil
+}
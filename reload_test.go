@@ -0,0 +1,69 @@
+package queries
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"testing/fstest"
+)
+
+func TestReload(t *testing.T) {
+	dir := t.TempDir()
+	writeSQLFile(t, dir, "users.sql", "-- name: get-user\nSELECT * FROM users WHERE id = :id\n")
+	single := writeSQLFile(t, t.TempDir(), "single.sql", "-- name: ping\nSELECT 1\n")
+	fsys := fstest.MapFS{"sql/orders.sql": {Data: []byte("-- name: list-orders\nSELECT * FROM orders\n")}}
+
+	s := NewQueryStore()
+	if err := s.LoadFromDir(dir); err != nil {
+		t.Fatalf("LoadFromDir: %v", err)
+	}
+	if err := s.LoadFromFile(single); err != nil {
+		t.Fatalf("LoadFromFile: %v", err)
+	}
+	if err := s.LoadFromFS(fsys, "sql"); err != nil {
+		t.Fatalf("LoadFromFS: %v", err)
+	}
+	if err := s.LoadFromString("inline.sql", "-- name: inline\nSELECT 2\n"); err != nil {
+		t.Fatalf("LoadFromString: %v", err)
+	}
+
+	writeSQLFile(t, dir, "users.sql", "-- name: get-user\nSELECT * FROM users WHERE id = :id AND deleted_at IS NULL\n")
+	writeSQLFile(t, dir, "teams.sql", "-- name: list-teams\nSELECT * FROM teams\n")
+
+	if err := s.Reload(); err != nil {
+		t.Fatalf("Reload: %v", err)
+	}
+
+	expected := []string{"get-user", "list-orders", "list-teams", "ping"}
+	if names := s.QueryNames(); !reflect.DeepEqual(names, expected) {
+		t.Errorf("QueryNames after Reload: got %v, expected %v", names, expected)
+	}
+	if raw := s.MustHaveQuery("get-user").Raw; raw != "SELECT * FROM users WHERE id = :id AND deleted_at IS NULL" {
+		t.Errorf("get-user not reloaded: %q", raw)
+	}
+
+	// a broken file keeps the previous queries
+	writeSQLFile(t, dir, "users.sql", "-- name: get-user\nSELECT * FROM users WHERE id = :id AND org = $1\n")
+	if err := s.Reload(); err == nil {
+		t.Errorf("Reload: expected error for broken file")
+	}
+	if names := s.QueryNames(); !reflect.DeepEqual(names, expected) {
+		t.Errorf("QueryNames after failed Reload: got %v", names)
+	}
+
+	if err := os.Remove(filepath.Join(dir, "users.sql")); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Reload(); err != nil {
+		t.Fatalf("Reload: %v", err)
+	}
+	if s.Has("get-user") {
+		t.Errorf("query of removed file still loaded")
+	}
+
+	s.Clear()
+	if err := s.Reload(); err != nil || s.Len() != 0 {
+		t.Errorf("Reload after Clear: got %d queries, %v", s.Len(), err)
+	}
+}
@@ -3,69 +3,475 @@ package queries
 
 import (
 	"bufio"
+	"fmt"
+	"io"
 	"path/filepath"
 	"regexp"
+	"sort"
 	"strings"
+	"unicode"
 )
 
+var (
+	// nameTagRE matches "-- name: get-user" and, for names with spaces,
+	// "-- name: \"get user\""
+	nameTagRE  = regexp.MustCompile(`^\s*--\s*name:\s*(?:"([^"]+)"|(\S+))`)
+	aliasTagRE = regexp.MustCompile(`^\s*--\s*alias:\s*(?:"([^"]+)"|(\S+))`)
+	// paramTagRE matches "-- param: id int" type annotations
+	paramTagRE = regexp.MustCompile(`^\s*--\s*param:\s*([A-Za-z][A-Za-z0-9_]*)\s+(.*\S)\s*$`)
+	// includeTagRE matches "-- include: common-ctes" lines of a query body
+	includeTagRE = regexp.MustCompile(`(?m)^[ \t]*--[ \t]*include:[ \t]*(?:"([^"]+)"|(\S+))[ \t]*\r?$`)
+	// blockDirectiveRE matches a directive written as a block comment on
+	// its own line, e.g. "/* name: get-user */"
+	blockDirectiveRE = regexp.MustCompile(`^\s*/\*\s*([A-Za-z][A-Za-z0-9_-]*:.*?)\s*\*/\s*$`)
+	metadataRE       = regexp.MustCompile(`^\s*--\s*([A-Za-z][A-Za-z0-9_-]*):\s*(.*\S)\s*$`)
+	// commentWordRE splits a comment line into its first word and the rest,
+	// for the Strict checks
+	commentWordRE = regexp.MustCompile(`^\s*(--|/\*)\s*([A-Za-z][A-Za-z0-9_-]*)(.*)$`)
+)
+
+// directives are the keywords the scanner handles itself instead of
+// recording them as metadata
+var directives = []string{"name", "alias", "include", "param"}
+
 type Scanner struct {
 	line    string
-	queries map[string]string
+	queries map[string]*ScannedQuery
 	current string
+
+	fileName string
+	// lineNo is the number of the current line, currentLine the line of
+	// the name directive of the current query (0 before the first one)
+	lineNo      int
+	currentLine int
+	// discarded collects a query repeating an earlier name in the file
+	discarded *ScannedQuery
+
+	// Errors holds the problems found by the last Run, in line order
+	Errors []error
+
+	// HeaderOnly restricts directives and metadata to the comment lines
+	// before the first SQL line of a query. Once the body starts, comment
+	// lines are kept as SQL until a line ending with ";" or a blank line.
+	HeaderOnly bool
+
+	// Strict reports comment lines that look like malformed directives,
+	// e.g. "-- name foo" or "-- nam: foo", in Errors. They are still
+	// scanned as before, as SQL or metadata.
+	Strict bool
+}
+
+// ScannedQuery is a single query body with the metadata found in the
+// comment lines of its header
+type ScannedQuery struct {
+	Query    string
+	Metadata map[string]string
+	// MetadataOriginal holds the same entries as Metadata keyed by the
+	// key as authored (e.g. "Description" instead of "description")
+	MetadataOriginal map[string]string
+	// Aliases are the additional names from "-- alias:" directives
+	Aliases []string
+	// ParamTypes maps parameter names to the types declared by "-- param:"
+	// annotations; nil without annotations
+	ParamTypes map[string]string
+	// Line is the line of the name directive, or of the first line of a
+	// query named after the file
+	Line int
+
+	// named is false for the query named after the file
+	named bool
+}
+
+// ScanError is a malformed part of a scanned file. The scanner skips it
+// and continues.
+type ScanError struct {
+	Path    string
+	Line    int
+	Query   string
+	Message string
+}
+
+func (e *ScanError) Error() string {
+	return fmt.Sprintf("%s:%d: %s", e.Path, e.Line, e.Message)
 }
 
 type stateFn func(*Scanner) stateFn
 
+// maxLineLength is the longest line NewLineScanner reads, far beyond the
+// 64KB default of bufio.Scanner so generated queries with e.g. a long IN
+// list on a single line load
+const maxLineLength = 64 << 20
+
+// NewLineScanner returns a line scanner for Run whose buffer grows up to
+// 64MB per line
+func NewLineScanner(r io.Reader) *bufio.Scanner {
+	lines := bufio.NewScanner(r)
+	lines.Buffer(make([]byte, 0, 64*1024), maxLineLength)
+
+	return lines
+}
+
 func getTag(line string) string {
-	re := regexp.MustCompile("^\\s*--\\s*name:\\s*(\\S+)")
+	return getDirective(nameTagRE, line)
+}
+
+func getAlias(line string) string {
+	return getDirective(aliasTagRE, line)
+}
+
+// getDirective returns the quoted or single token value of a directive
+func getDirective(re *regexp.Regexp, line string) string {
 	matches := re.FindStringSubmatch(line)
 	if matches == nil {
 		return ""
 	}
-	return matches[1]
+	if matches[1] != "" {
+		return matches[1]
+	}
+	return matches[2]
+}
+
+// getMetadata parses "-- key: value" comment lines. The returned key is
+// trimmed but keeps its original casing.
+func getMetadata(line string) (string, string, bool) {
+	matches := metadataRE.FindStringSubmatch(line)
+	if matches == nil {
+		return "", "", false
+	}
+	return matches[1], matches[2], true
+}
+
+// directiveLine rewrites a block comment directive to the "--" form so the
+// same patterns match both
+func directiveLine(line string) string {
+	if matches := blockDirectiveRE.FindStringSubmatch(line); matches != nil {
+		return "-- " + matches[1]
+	}
+	return line
 }
 
 func initialState(s *Scanner) stateFn {
-	if tag := getTag(s.line); len(tag) > 0 {
-		s.current = tag
+	if tag := getTag(directiveLine(s.line)); len(tag) > 0 {
+		s.setCurrent(tag)
 		return queryState
 	}
 	return initialState
 }
 
 func queryState(s *Scanner) stateFn {
-	if tag := getTag(s.line); len(tag) > 0 {
-		s.current = tag
+	line := directiveLine(s.line)
+
+	if tag := getTag(line); len(tag) > 0 {
+		s.setCurrent(tag)
+	} else if alias := getAlias(line); len(alias) > 0 {
+		if err := validateName(alias); err != nil {
+			s.addError(s.lineNo, s.current, fmt.Sprintf("invalid alias '%s': %v", alias, err))
+		} else {
+			sq := s.scanned()
+			sq.Aliases = append(sq.Aliases, alias)
+		}
+	} else if matches := paramTagRE.FindStringSubmatch(line); matches != nil {
+		sq := s.scanned()
+		if sq.ParamTypes == nil {
+			sq.ParamTypes = make(map[string]string)
+		}
+		sq.ParamTypes[matches[1]] = matches[2]
+	} else if include := getDirective(includeTagRE, line); len(include) > 0 {
+		// kept in the body, the store inlines the query once all files
+		// are loaded
+		s.appendLine(line)
+	} else if key, value, ok := getMetadata(line); ok && s.inHeader() {
+		s.appendMetadata(key, value)
 	} else {
 		s.appendQueryLine()
+		if s.HeaderOnly && !s.endsStatement() {
+			return bodyState
+		}
 	}
 	return queryState
 }
 
+// bodyState is used in HeaderOnly mode and treats every line as SQL until
+// the end of the statement
+func bodyState(s *Scanner) stateFn {
+	s.appendQueryLine()
+	if s.endsStatement() {
+		return queryState
+	}
+	return bodyState
+}
+
+// endsStatement reports whether the current line closes the query body
+func (s *Scanner) endsStatement() bool {
+	line := strings.TrimSpace(s.line)
+	return len(line) == 0 || strings.HasSuffix(line, ";")
+}
+
+// setCurrent starts the query named by the directive on the current line
+func (s *Scanner) setCurrent(name string) {
+	s.current = name
+	s.currentLine = s.lineNo
+	s.discarded = nil
+
+	if err := validateName(name); err != nil {
+		s.addError(s.lineNo, name, fmt.Sprintf("invalid query name '%s': %v", name, err))
+		s.discard()
+		return
+	}
+
+	// the first definition wins, a repeated name is reported and its body
+	// dropped
+	if first, ok := s.queries[name]; ok {
+		s.addError(s.lineNo, name, fmt.Sprintf("duplicate query name '%s', first defined on line %d", name, first.Line))
+		s.discard()
+		return
+	}
+
+	// register the query right away so an empty one is reported
+	s.scanned()
+}
+
+// discard drops the body of the current query
+func (s *Scanner) discard() {
+	s.discarded = &ScannedQuery{
+		Metadata:         make(map[string]string),
+		MetadataOriginal: make(map[string]string),
+	}
+}
+
+// validateName checks the characters of a name or alias from a directive:
+// letters, digits, "-", "_", "." and "/", and spaces between words for
+// quoted names. Other characters break generated code and namespaces.
+func validateName(name string) error {
+	if strings.TrimSpace(name) != name {
+		return fmt.Errorf("leading or trailing space")
+	}
+
+	for _, r := range name {
+		switch {
+		case unicode.IsLetter(r), unicode.IsDigit(r):
+		case r == '-', r == '_', r == '.', r == '/', r == ' ':
+		default:
+			return fmt.Errorf("invalid character %q", r)
+		}
+	}
+
+	return nil
+}
+
+func (s *Scanner) scanned() *ScannedQuery {
+	if s.discarded != nil {
+		return s.discarded
+	}
+
+	sq, ok := s.queries[s.current]
+	if !ok {
+		line := s.currentLine
+		if line == 0 {
+			line = s.lineNo
+		}
+
+		sq = &ScannedQuery{
+			Metadata:         make(map[string]string),
+			MetadataOriginal: make(map[string]string),
+			Line:             line,
+			named:            s.currentLine != 0,
+		}
+		s.queries[s.current] = sq
+	}
+
+	return sq
+}
+
+// inHeader reports whether the current query has no SQL line yet. Only
+// comments in the header are metadata, "-- TODO: ..." in the body is SQL.
+func (s *Scanner) inHeader() bool {
+	sq := s.discarded
+	if sq == nil {
+		sq = s.queries[s.current]
+	}
+
+	return sq == nil || len(sq.Query) == 0
+}
+
+func (s *Scanner) appendMetadata(key, value string) {
+	sq := s.scanned()
+	sq.Metadata[normalizeMetadataKey(key)] = value
+	sq.MetadataOriginal[key] = value
+}
+
 func (s *Scanner) appendQueryLine() {
-	current := s.queries[s.current]
-	line := strings.Trim(s.line, " \t")
+	s.appendLine(s.line)
+}
+
+func (s *Scanner) appendLine(line string) {
+	line = strings.Trim(line, " \t")
 	if len(line) == 0 {
 		return
 	}
 
-	if len(current) > 0 {
-		current = current + "\n"
+	sq := s.scanned()
+	if len(sq.Query) > 0 {
+		sq.Query = sq.Query + "\n"
 	}
 
-	current = current + line
-	s.queries[s.current] = current
+	sq.Query = sq.Query + line
 }
 
+// Run scans the file and returns the body of every query by name. See
+// ScanQueries for their metadata and directives.
 func (s *Scanner) Run(fileName string, io *bufio.Scanner) map[string]string {
-	s.queries = make(map[string]string)
+	queries := make(map[string]string)
+	for name, sq := range s.ScanQueries(fileName, io) {
+		queries[name] = sq.Query
+	}
+
+	return queries
+}
+
+// ScanQueries scans the file and returns every query by name with the
+// metadata and directives of its header. Problems are reported in Errors.
+func (s *Scanner) ScanQueries(fileName string, io *bufio.Scanner) map[string]*ScannedQuery {
+	s.queries = make(map[string]*ScannedQuery)
 
 	s.current = filepath.Base(strings.TrimSuffix(fileName, filepath.Ext(fileName)))
+	s.fileName = fileName
+	s.lineNo, s.currentLine = 0, 0
+	s.discarded = nil
+	s.Errors = nil
 
 	for state := queryState; io.Scan(); {
 		s.line = io.Text()
+		s.lineNo++
+		if s.Strict {
+			if message := malformedDirective(s.line); message != "" {
+				s.addError(s.lineNo, s.current, message)
+			}
+		}
 		state = state(s)
 	}
 
+	// metadata without a body does not make a query
+	for name, sq := range s.queries {
+		if len(sq.Query) == 0 {
+			message := fmt.Sprintf("query '%s' has no body", name)
+			if !sq.named {
+				message = "metadata before the first name directive"
+			}
+			s.addError(sq.Line, name, message)
+
+			delete(s.queries, name)
+		}
+	}
+	sort.SliceStable(s.Errors, func(i, j int) bool {
+		return s.Errors[i].(*ScanError).Line < s.Errors[j].(*ScanError).Line
+	})
+
 	return s.queries
 }
+
+func (s *Scanner) addError(line int, query, message string) {
+	s.Errors = append(s.Errors, &ScanError{Path: s.fileName, Line: line, Query: query, Message: message})
+}
+
+// malformedDirective returns why the line looks like a broken directive, or
+// "" for well formed directives and any other line
+func malformedDirective(line string) string {
+	matches := commentWordRE.FindStringSubmatch(line)
+	if matches == nil {
+		return ""
+	}
+	opening, word, rest := matches[1], matches[2], matches[3]
+	lower := strings.ToLower(word)
+
+	if opening == "/*" {
+		if isDirective(lower) && strings.HasPrefix(strings.TrimSpace(rest), ":") && !strings.Contains(rest, "*/") {
+			return fmt.Sprintf("unclosed block comment in '%s' directive", lower)
+		}
+		line = directiveLine(line)
+		if matches = commentWordRE.FindStringSubmatch(line); matches == nil || matches[1] != "--" {
+			return ""
+		}
+		word, rest = matches[2], matches[3]
+	}
+
+	if !strings.HasPrefix(rest, ":") {
+		// "-- name get-user", but not a comment like "-- name of the user"
+		value := strings.Fields(rest)
+		if (lower == "name" || lower == "alias") && len(value) == 1 && strings.HasPrefix(rest, " ") {
+			return fmt.Sprintf("'%s' directive without a colon", lower)
+		}
+		return ""
+	}
+
+	switch value := strings.TrimSpace(rest[1:]); {
+	case isDirective(word):
+		if value == "" {
+			return fmt.Sprintf("'%s' directive without a value", word)
+		}
+	case isDirective(lower):
+		return fmt.Sprintf("directives are case sensitive, use '%s' instead of '%s'", lower, word)
+	default:
+		if directive := closestDirective(lower); directive != "" {
+			return fmt.Sprintf("unknown directive '%s', did you mean '%s'?", word, directive)
+		}
+	}
+
+	return ""
+}
+
+func isDirective(word string) bool {
+	for _, directive := range directives {
+		if word == directive {
+			return true
+		}
+	}
+	return false
+}
+
+// closestDirective returns the directive the word is a likely typo of: a
+// single character added, dropped, changed or swapped with its neighbour
+func closestDirective(word string) string {
+	if len(word) < 3 {
+		return ""
+	}
+
+	for _, directive := range directives {
+		if editDistance(word, directive) == 1 {
+			return directive
+		}
+	}
+	return ""
+}
+
+// editDistance is the Levenshtein distance of two ASCII words, counting a
+// swap of adjacent characters as a single edit
+func editDistance(a, b string) int {
+	d := make([][]int, len(a)+1)
+	for i := range d {
+		d[i] = make([]int, len(b)+1)
+		d[i][0] = i
+	}
+	for j := range d[0] {
+		d[0][j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			d[i][j] = min(d[i-1][j]+1, d[i][j-1]+1, d[i-1][j-1]+cost)
+			if i > 1 && j > 1 && a[i-1] == b[j-2] && a[i-2] == b[j-1] {
+				d[i][j] = min(d[i][j], d[i-2][j-2]+1)
+			}
+		}
+	}
+
+	return d[len(a)][len(b)]
+}
+
+func normalizeMetadataKey(key string) string {
+	return strings.ToLower(strings.TrimSpace(key))
+}
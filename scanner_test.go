@@ -0,0 +1,486 @@
+package queries
+
+import (
+	"bufio"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func scan(t *testing.T, fileName, content string) map[string]*ScannedQuery {
+	t.Helper()
+
+	scanner := &Scanner{}
+	return scanner.ScanQueries(fileName, bufio.NewScanner(strings.NewReader(content)))
+}
+
+func TestScannerMetadata(t *testing.T) {
+	queries := scan(t, "users.sql", `-- name: get-user
+-- Description: Fetch a single user
+-- timeout: 50ms
+SELECT * FROM users WHERE id = :id
+
+-- name: list-users
+SELECT * FROM users
+`)
+
+	if len(queries) != 2 {
+		t.Fatalf("got %d queries, expected 2", len(queries))
+	}
+
+	q := queries["get-user"]
+	if q.Query != "SELECT * FROM users WHERE id = :id" {
+		t.Errorf("Query: got %q", q.Query)
+	}
+
+	expected := map[string]string{"description": "Fetch a single user", "timeout": "50ms"}
+	if !reflect.DeepEqual(q.Metadata, expected) {
+		t.Errorf("Metadata: got %v, expected %v", q.Metadata, expected)
+	}
+
+	expectedOriginal := map[string]string{"Description": "Fetch a single user", "timeout": "50ms"}
+	if !reflect.DeepEqual(q.MetadataOriginal, expectedOriginal) {
+		t.Errorf("MetadataOriginal: got %v, expected %v", q.MetadataOriginal, expectedOriginal)
+	}
+
+	if len(queries["list-users"].Metadata) != 0 {
+		t.Errorf("list-users should not have metadata: %v", queries["list-users"].Metadata)
+	}
+}
+
+func TestScannerDoesNotCreateEmptyQueries(t *testing.T) {
+	queries := scan(t, "users.sql", `-- name: only-metadata
+-- description: nothing here
+
+-- name: get-user
+SELECT 1
+`)
+
+	if _, ok := queries["only-metadata"]; ok {
+		t.Errorf("query without body should not be created")
+	}
+	if _, ok := queries["get-user"]; !ok {
+		t.Errorf("get-user should be created")
+	}
+}
+
+func TestScannerBodyComments(t *testing.T) {
+	queries := scan(t, "users.sql", `-- name: list-users
+-- Description: All users
+SELECT *
+-- TODO: remove this
+FROM users
+`)
+
+	q := queries["list-users"]
+	if expected := "SELECT *\n-- TODO: remove this\nFROM users"; q.Query != expected {
+		t.Errorf("Query: got %q, expected %q", q.Query, expected)
+	}
+	if expected := map[string]string{"description": "All users"}; !reflect.DeepEqual(q.Metadata, expected) {
+		t.Errorf("Metadata: got %v, expected %v", q.Metadata, expected)
+	}
+}
+
+func TestScannerRun(t *testing.T) {
+	scanner := &Scanner{}
+	queries := scanner.Run("users.sql", bufio.NewScanner(strings.NewReader("-- name: get-user\n-- timeout: 5s\nSELECT * FROM users WHERE id = :id\n\n-- name: list-users\nSELECT * FROM users\n")))
+
+	expected := map[string]string{
+		"get-user":   "SELECT * FROM users WHERE id = :id",
+		"list-users": "SELECT * FROM users",
+	}
+	if !reflect.DeepEqual(queries, expected) {
+		t.Errorf("Run: got %v, expected %v", queries, expected)
+	}
+}
+
+func TestScannerHeaderOnly(t *testing.T) {
+	content := `-- name: get-profile
+-- description: Profile with display name
+SELECT id,
+-- name: the display name from the JSON column
+  data->>'name' AS name
+FROM users
+WHERE id = :id;
+-- name: list-users
+SELECT * FROM users
+`
+
+	scanner := &Scanner{HeaderOnly: true}
+	queries := scanner.ScanQueries("users.sql", bufio.NewScanner(strings.NewReader(content)))
+
+	if len(queries) != 2 {
+		t.Fatalf("got %d queries, expected 2: %v", len(queries), queries)
+	}
+
+	expected := "SELECT id,\n-- name: the display name from the JSON column\ndata->>'name' AS name\nFROM users\nWHERE id = :id;"
+	if q := queries["get-profile"]; q == nil || q.Query != expected {
+		t.Errorf("get-profile: got %+v, expected query %q", q, expected)
+	}
+	if q := queries["get-profile"]; q != nil && q.Metadata["description"] != "Profile with display name" {
+		t.Errorf("header metadata was not parsed: %v", q.Metadata)
+	}
+	if q := queries["list-users"]; q == nil || q.Query != "SELECT * FROM users" {
+		t.Errorf("list-users: got %+v", q)
+	}
+
+	// without the option the body comment starts a new query
+	if queries := scan(t, "users.sql", content); len(queries) != 3 {
+		t.Errorf("default mode: got %d queries, expected 3", len(queries))
+	}
+}
+
+func TestScannerHeaderOnlyBlankLineEndsBody(t *testing.T) {
+	scanner := &Scanner{HeaderOnly: true}
+	queries := scanner.ScanQueries("users.sql", bufio.NewScanner(strings.NewReader(`-- name: first
+SELECT 1
+
+-- name: second
+SELECT 2
+`)))
+
+	if len(queries) != 2 || queries["first"].Query != "SELECT 1" || queries["second"].Query != "SELECT 2" {
+		t.Errorf("unexpected queries: %v", queries)
+	}
+}
+
+func TestScannerQuotedNames(t *testing.T) {
+	queries := scan(t, "users.sql", `-- name: "get user by email"
+SELECT * FROM users WHERE email = :email
+
+-- name: list-active-users
+SELECT * FROM users WHERE active
+
+-- name: get user by id
+SELECT * FROM users WHERE id = :id
+`)
+
+	expected := map[string]string{
+		"get user by email": "SELECT * FROM users WHERE email = :email",
+		"list-active-users": "SELECT * FROM users WHERE active",
+		// unquoted names end at the first space
+		"get": "SELECT * FROM users WHERE id = :id",
+	}
+
+	got := make(map[string]string, len(queries))
+	for name, q := range queries {
+		got[name] = q.Query
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("got %v, expected %v", got, expected)
+	}
+}
+
+func TestScannerLine(t *testing.T) {
+	queries := scan(t, "users.sql", `SELECT 'unnamed'
+
+-- name: get-user
+-- description: Fetch a user
+SELECT * FROM users WHERE id = :id
+
+-- name: list-users
+SELECT * FROM users
+`)
+
+	expected := map[string]int{"users": 1, "get-user": 3, "list-users": 7}
+	got := make(map[string]int, len(queries))
+	for name, q := range queries {
+		got[name] = q.Line
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("Line: got %v, expected %v", got, expected)
+	}
+}
+
+func TestScannerErrors(t *testing.T) {
+	testCases := []struct {
+		name           string
+		content        string
+		expectedErrors []string
+	}{
+		{
+			name:           "well formed",
+			content:        "-- name: get-user\nSELECT 1\n",
+			expectedErrors: nil,
+		},
+		{
+			name:           "empty body",
+			content:        "-- name: get-user\n-- description: nothing here\n\n-- name: list-users\nSELECT 1\n",
+			expectedErrors: []string{"users.sql:1: query 'get-user' has no body"},
+		},
+		{
+			name:           "name without anything",
+			content:        "-- name: get-user\n-- name: list-users\nSELECT 1\n-- name: count-users\n",
+			expectedErrors: []string{"users.sql:1: query 'get-user' has no body", "users.sql:4: query 'count-users' has no body"},
+		},
+		{
+			name:           "duplicate name",
+			content:        "-- name: get-user\nSELECT 1\n\n-- name: list-users\nSELECT 2\n\n-- name: get-user\nSELECT 3\n",
+			expectedErrors: []string{"users.sql:7: duplicate query name 'get-user', first defined on line 1"},
+		},
+		{
+			name:           "metadata before any name",
+			content:        "-- description: orphan\n\n-- name: get-user\nSELECT 1\n",
+			expectedErrors: []string{"users.sql:1: metadata before the first name directive"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			scanner := &Scanner{}
+			scanner.Run("users.sql", bufio.NewScanner(strings.NewReader(tc.content)))
+
+			var got []string
+			for _, err := range scanner.Errors {
+				got = append(got, err.Error())
+			}
+			if !reflect.DeepEqual(got, tc.expectedErrors) {
+				t.Errorf("Errors: got %q, expected %q", got, tc.expectedErrors)
+			}
+		})
+	}
+}
+
+func TestScannerDuplicateNameKeepsFirst(t *testing.T) {
+	queries := scan(t, "users.sql", `-- name: get-user
+-- description: first
+SELECT * FROM users WHERE id = :id
+
+-- name: get-user
+-- description: copy
+SELECT * FROM users WHERE email = :email
+`)
+
+	q := queries["get-user"]
+	if q.Query != "SELECT * FROM users WHERE id = :id" {
+		t.Errorf("Query: got %q, expected the first definition", q.Query)
+	}
+	if q.Metadata["description"] != "first" {
+		t.Errorf("Metadata: got %v, expected the first definition", q.Metadata)
+	}
+}
+
+func TestScannerAliases(t *testing.T) {
+	queries := scan(t, "users.sql", `-- name: get-user-by-id
+-- alias: get-user
+-- alias: "fetch user"
+-- description: Fetch a user
+SELECT * FROM users WHERE id = :id
+`)
+
+	q := queries["get-user-by-id"]
+	if !reflect.DeepEqual(q.Aliases, []string{"get-user", "fetch user"}) {
+		t.Errorf("Aliases: got %q", q.Aliases)
+	}
+	if _, ok := q.Metadata["alias"]; ok {
+		t.Errorf("alias recorded as metadata: %v", q.Metadata)
+	}
+}
+
+func TestScannerBlockCommentDirectives(t *testing.T) {
+	queries := scan(t, "users.sql", `/* name: get-user */
+/* description: Fetch a single user */
+SELECT * FROM users WHERE id = :id
+
+-- name: list-users
+/* timeout: 50ms */
+SELECT * FROM users /* inline comments stay */
+
+/* name: "count users" */
+-- description: Count all users
+SELECT count(*) FROM users
+`)
+
+	expected := map[string]*ScannedQuery{
+		"get-user": {
+			Query:    "SELECT * FROM users WHERE id = :id",
+			Metadata: map[string]string{"description": "Fetch a single user"},
+		},
+		"list-users": {
+			Query:    "SELECT * FROM users /* inline comments stay */",
+			Metadata: map[string]string{"timeout": "50ms"},
+		},
+		"count users": {
+			Query:    "SELECT count(*) FROM users",
+			Metadata: map[string]string{"description": "Count all users"},
+		},
+	}
+
+	if len(queries) != len(expected) {
+		t.Fatalf("got %d queries, expected %d", len(queries), len(expected))
+	}
+	for name, e := range expected {
+		q, ok := queries[name]
+		if !ok {
+			t.Errorf("query %q not found", name)
+			continue
+		}
+		if q.Query != e.Query {
+			t.Errorf("%s Query: got %q, expected %q", name, q.Query, e.Query)
+		}
+		if !reflect.DeepEqual(q.Metadata, e.Metadata) {
+			t.Errorf("%s Metadata: got %v, expected %v", name, q.Metadata, e.Metadata)
+		}
+	}
+}
+
+func TestScannerParamTypes(t *testing.T) {
+	queries := scan(t, "users.sql", `-- name: get-user
+-- param: id int
+-- param: email text
+-- param: created_at timestamp with time zone
+-- description: Fetch a user
+SELECT * FROM users WHERE id = :id AND email = :email AND created_at > :created_at
+
+-- name: list-users
+SELECT * FROM users
+`)
+
+	expected := map[string]string{"id": "int", "email": "text", "created_at": "timestamp with time zone"}
+	if q := queries["get-user"]; !reflect.DeepEqual(q.ParamTypes, expected) {
+		t.Errorf("ParamTypes: got %v, expected %v", q.ParamTypes, expected)
+	}
+	if _, ok := queries["get-user"].Metadata["param"]; ok {
+		t.Errorf("param annotation recorded as metadata")
+	}
+	if strings.Contains(queries["get-user"].Query, "param:") {
+		t.Errorf("param annotation kept in the body: %q", queries["get-user"].Query)
+	}
+	if types := queries["list-users"].ParamTypes; types != nil {
+		t.Errorf("ParamTypes without annotations: got %v", types)
+	}
+}
+
+func TestScannerStrict(t *testing.T) {
+	testCases := []struct {
+		name           string
+		content        string
+		expectedErrors []string
+	}{
+		{
+			name:    "well formed",
+			content: "-- name: get-user\n-- alias: user\n-- note: keep in sync\n-- description: name of the user\n/* timeout: 5s */\nSELECT 1\n",
+		},
+		{
+			name:    "no space",
+			content: "--name:get-user\nSELECT 1\n",
+		},
+		{
+			name:           "missing colon",
+			content:        "-- name get-user\nSELECT 1\n",
+			expectedErrors: []string{"users.sql:1: 'name' directive without a colon"},
+		},
+		{
+			name:    "comment mentioning a directive",
+			content: "-- name: get-user\n-- name of the user is unique\nSELECT 1\n",
+		},
+		{
+			name:           "typo",
+			content:        "-- name: get-user\n-- nam: fetch-user\n-- alais: user\n-- inlcude: filter\nSELECT 1\n",
+			expectedErrors: []string{"users.sql:2: unknown directive 'nam', did you mean 'name'?", "users.sql:3: unknown directive 'alais', did you mean 'alias'?", "users.sql:4: unknown directive 'inlcude', did you mean 'include'?"},
+		},
+		{
+			name:           "case",
+			content:        "-- Name: get-user\nSELECT 1\n",
+			expectedErrors: []string{"users.sql:1: directives are case sensitive, use 'name' instead of 'Name'"},
+		},
+		{
+			name:           "empty",
+			content:        "-- name: get-user\n-- alias:\nSELECT 1\n",
+			expectedErrors: []string{"users.sql:2: 'alias' directive without a value"},
+		},
+		{
+			name:           "unclosed block comment",
+			content:        "/* name: get-user\nSELECT 1\n",
+			expectedErrors: []string{"users.sql:1: unclosed block comment in 'name' directive"},
+		},
+		{
+			name:           "block comment typo",
+			content:        "-- name: get-user\n/* nmae: list-users */\nSELECT 1\n",
+			expectedErrors: []string{"users.sql:2: unknown directive 'nmae', did you mean 'name'?"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			scanner := &Scanner{Strict: true}
+			scanner.Run("users.sql", bufio.NewScanner(strings.NewReader(tc.content)))
+
+			var got []string
+			for _, err := range scanner.Errors {
+				got = append(got, err.Error())
+			}
+			if !reflect.DeepEqual(got, tc.expectedErrors) {
+				t.Errorf("Errors: got %q, expected %q", got, tc.expectedErrors)
+			}
+
+			lax := &Scanner{}
+			lax.Run("users.sql", bufio.NewScanner(strings.NewReader(tc.content)))
+			if len(lax.Errors) != 0 {
+				t.Errorf("Errors without Strict: got %v", lax.Errors)
+			}
+		})
+	}
+}
+
+func TestScannerNameCharset(t *testing.T) {
+	testCases := []struct {
+		name           string
+		content        string
+		expectedNames  []string
+		expectedErrors []string
+	}{
+		{
+			name:          "valid",
+			content:       "-- name: get-user_v2.1\nSELECT 1\n\n-- name: users/list\nSELECT 2\n\n-- name: \"count all users\"\nSELECT 3\n\n-- name: créer-utilisateur\nSELECT 4\n",
+			expectedNames: []string{"count all users", "créer-utilisateur", "get-user_v2.1", "users/list"},
+		},
+		{
+			name:           "punctuation",
+			content:        "-- name: get-user;drop\nSELECT 1\n\n-- name: list-users\nSELECT 2\n",
+			expectedNames:  []string{"list-users"},
+			expectedErrors: []string{"users.sql:1: invalid query name 'get-user;drop': invalid character ';'"},
+		},
+		{
+			name:           "control character",
+			content:        "-- name: \"get\tuser\"\nSELECT 1\n",
+			expectedErrors: []string{"users.sql:1: invalid query name 'get\tuser': invalid character '\\t'"},
+		},
+		{
+			name:           "padded quoted name",
+			content:        "-- name: \" get user\"\nSELECT 1\n",
+			expectedErrors: []string{"users.sql:1: invalid query name ' get user': leading or trailing space"},
+		},
+		{
+			name:           "invalid alias",
+			content:        "-- name: get-user\n-- alias: user#1\nSELECT 1\n",
+			expectedNames:  []string{"get-user"},
+			expectedErrors: []string{"users.sql:2: invalid alias 'user#1': invalid character '#'"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			scanner := &Scanner{}
+			queries := scanner.ScanQueries("users.sql", bufio.NewScanner(strings.NewReader(tc.content)))
+
+			var names []string
+			for name := range queries {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			if !reflect.DeepEqual(names, tc.expectedNames) {
+				t.Errorf("names: got %q, expected %q", names, tc.expectedNames)
+			}
+
+			var errors []string
+			for _, err := range scanner.Errors {
+				errors = append(errors, err.Error())
+			}
+			if !reflect.DeepEqual(errors, tc.expectedErrors) {
+				t.Errorf("Errors: got %q, expected %q", errors, tc.expectedErrors)
+			}
+		})
+	}
+}
@@ -0,0 +1,49 @@
+package queries
+
+// StoreStats summarizes the loaded queries, see QueryStore.Stats
+type StoreStats struct {
+	// Queries is the number of loaded queries, aliases not included
+	Queries int
+	// Aliases is the number of additional names
+	Aliases int
+	// Files is the number of distinct source paths
+	Files int
+	// WithParams counts the queries with at least one parameter
+	WithParams int
+	// WithMetadata counts the queries with at least one metadata entry
+	WithMetadata int
+	// ByStyle counts the queries per parameter style, StyleNone for the
+	// ones without parameters
+	ByStyle map[ParamStyle]int
+	// Warnings is the number of recorded warnings
+	Warnings int
+}
+
+// Stats counts the loaded queries by a few properties, for logging and
+// metrics
+func (s *QueryStore) Stats() StoreStats {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	stats := StoreStats{
+		Queries:  len(s.queries),
+		Aliases:  len(s.aliases),
+		ByStyle:  make(map[ParamStyle]int),
+		Warnings: len(s.warnings),
+	}
+
+	files := make(map[string]bool)
+	for _, q := range s.queries {
+		files[q.Path] = true
+		if len(q.Mapping) > 0 {
+			stats.WithParams++
+		}
+		if len(q.Metadata) > 0 {
+			stats.WithMetadata++
+		}
+		stats.ByStyle[q.Style]++
+	}
+	stats.Files = len(files)
+
+	return stats
+}
@@ -0,0 +1,36 @@
+package queries
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestStats(t *testing.T) {
+	dir := t.TempDir()
+	writeSQLFile(t, dir, "users.sql", "-- name: get-user\n-- alias: user\n-- description: Fetch a user\nSELECT * FROM users WHERE id = :id\n\n"+
+		"-- name: list-users\n-- WHERE id = :id\nSELECT * FROM users\n")
+	writeSQLFile(t, dir, "orders.sql", "-- name: get-order\n-- timeout: 50ms\nSELECT * FROM orders WHERE id = $1\n\n"+
+		"-- name: find-orders\nSELECT * FROM orders WHERE user_id = @user_id AND status = @status\n")
+
+	s := NewQueryStore(WithAtSignParams(true))
+	if err := s.LoadFromDir(dir); err != nil {
+		t.Fatalf("LoadFromDir: %v", err)
+	}
+
+	expected := StoreStats{
+		Queries:      4,
+		Aliases:      1,
+		Files:        2,
+		WithParams:   3,
+		WithMetadata: 2,
+		ByStyle:      map[ParamStyle]int{StyleNone: 1, StyleColon: 1, StylePositional: 1, StyleAtSign: 1},
+		Warnings:     1,
+	}
+	if stats := s.Stats(); !reflect.DeepEqual(stats, expected) {
+		t.Errorf("Stats: got %+v, expected %+v", stats, expected)
+	}
+
+	if stats := NewQueryStore().Stats(); stats.Queries != 0 || len(stats.ByStyle) != 0 {
+		t.Errorf("Stats of an empty store: got %+v", stats)
+	}
+}
@@ -0,0 +1,120 @@
+package queries
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+)
+
+// stmtKey identifies a cached statement
+type stmtKey struct {
+	db   *sql.DB
+	name string
+}
+
+// Prepare returns the statement for the named query prepared on db. It is
+// prepared once and cached until the query is replaced or removed, or the
+// store is closed. The query may be looked up by an alias. No lock is held
+// while the database prepares the statement.
+func (s *QueryStore) Prepare(ctx context.Context, db *sql.DB, name string) (*sql.Stmt, error) {
+	for {
+		q, stmt, err := s.cachedStmt(db, name)
+		if stmt != nil || err != nil {
+			return stmt, err
+		}
+
+		stmt, err = db.PrepareContext(ctx, q.SQL())
+		if errors.Is(err, driver.ErrBadConn) {
+			// the connection went away, prepare on a fresh one
+			stmt, err = db.PrepareContext(ctx, q.SQL())
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Error preparing query '%s': %w", q.Name, err)
+		}
+
+		if cached, ok := s.cacheStmt(db, q, stmt); ok {
+			return cached, nil
+		}
+		// the query was replaced while preparing, prepare the new one
+	}
+}
+
+// cachedStmt looks up the query by name or alias and returns it with its
+// cached statement on db, if any
+func (s *QueryStore) cachedStmt(db *sql.DB, name string) (*Query, *sql.Stmt, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	q, ok := s.lookup(name)
+	if !ok {
+		return nil, nil, &QueryNotFoundError{Name: name}
+	}
+	if q.includeErr != nil {
+		return nil, nil, q.includeErr
+	}
+
+	s.stmtMu.Lock()
+	defer s.stmtMu.Unlock()
+
+	return q, s.stmts[stmtKey{db: db, name: q.Name}], nil
+}
+
+// cacheStmt stores stmt prepared for q unless another caller cached one in
+// the meantime, which is returned instead. It reports false, closing stmt,
+// when q is no longer loaded.
+func (s *QueryStore) cacheStmt(db *sql.DB, q *Query, stmt *sql.Stmt) (*sql.Stmt, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.queries[q.Name] != q {
+		stmt.Close()
+		return nil, false
+	}
+
+	s.stmtMu.Lock()
+	defer s.stmtMu.Unlock()
+
+	key := stmtKey{db: db, name: q.Name}
+	if cached, ok := s.stmts[key]; ok {
+		stmt.Close()
+		return cached, true
+	}
+
+	if s.stmts == nil {
+		s.stmts = make(map[stmtKey]*sql.Stmt)
+	}
+	s.stmts[key] = stmt
+
+	return stmt, true
+}
+
+// Close closes all statements cached by Prepare. The store stays usable.
+func (s *QueryStore) Close() error {
+	s.stmtMu.Lock()
+	defer s.stmtMu.Unlock()
+
+	var errs []error
+	for key, stmt := range s.stmts {
+		if err := stmt.Close(); err != nil {
+			errs = append(errs, err)
+		}
+		delete(s.stmts, key)
+	}
+
+	return errors.Join(errs...)
+}
+
+// closeStmts closes the cached statements of the query on every database
+func (s *QueryStore) closeStmts(name string) {
+	s.stmtMu.Lock()
+	defer s.stmtMu.Unlock()
+
+	for key, stmt := range s.stmts {
+		if key.name == name {
+			stmt.Close()
+			delete(s.stmts, key)
+		}
+	}
+}
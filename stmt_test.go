@@ -0,0 +1,136 @@
+package queries
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestStorePrepare(t *testing.T) {
+	db, _ := openFakeDB(t, nil)
+	other, _ := openFakeDB(t, nil)
+	ctx := context.Background()
+
+	s := NewQueryStore(WithDuplicatePolicy(PolicyReplace))
+	if err := s.LoadFromString("users.sql", "-- name: get-user\nSELECT * FROM users WHERE id = :id\n"); err != nil {
+		t.Fatal(err)
+	}
+
+	first, err := s.Prepare(ctx, db, "get-user")
+	if err != nil {
+		t.Fatalf("Prepare: %v", err)
+	}
+	second, err := s.Prepare(ctx, db, "get-user")
+	if err != nil {
+		t.Fatalf("Prepare: %v", err)
+	}
+	if first != second {
+		t.Errorf("Prepare: got a new statement for the same query and db")
+	}
+
+	onOther, err := s.Prepare(ctx, other, "get-user")
+	if err != nil {
+		t.Fatalf("Prepare: %v", err)
+	}
+	if onOther == first {
+		t.Errorf("Prepare: statement shared between databases")
+	}
+
+	// replacing the query drops its statement
+	if err := s.LoadFromString("users.sql", "-- name: get-user\nSELECT id FROM users WHERE id = :id\n"); err != nil {
+		t.Fatal(err)
+	}
+	replaced, err := s.Prepare(ctx, db, "get-user")
+	if err != nil {
+		t.Fatalf("Prepare: %v", err)
+	}
+	if replaced == first {
+		t.Errorf("Prepare: statement of the replaced query reused")
+	}
+
+	if _, err := s.Prepare(ctx, db, "missing"); !errors.Is(err, ErrQueryNotFound) {
+		t.Errorf("Prepare(missing): got %v, expected ErrQueryNotFound", err)
+	}
+
+	if err := s.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	again, err := s.Prepare(ctx, db, "get-user")
+	if err != nil {
+		t.Fatalf("Prepare after Close: %v", err)
+	}
+	if again == replaced {
+		t.Errorf("Prepare after Close: closed statement reused")
+	}
+}
+
+func TestStorePrepareAlias(t *testing.T) {
+	db, _ := openFakeDB(t, nil)
+	ctx := context.Background()
+
+	s := NewQueryStore()
+	if err := s.LoadFromString("users.sql", "-- name: get-user\n-- alias: user-by-id\nSELECT * FROM users WHERE id = :id\n"); err != nil {
+		t.Fatal(err)
+	}
+
+	byAlias, err := s.Prepare(ctx, db, "user-by-id")
+	if err != nil {
+		t.Fatalf("Prepare by alias: %v", err)
+	}
+	byName, err := s.Prepare(ctx, db, "get-user")
+	if err != nil {
+		t.Fatalf("Prepare by name: %v", err)
+	}
+	if byAlias != byName {
+		t.Errorf("Prepare: alias and name got different statements")
+	}
+}
+
+func TestStorePrepareUnlocked(t *testing.T) {
+	db, fdb := openFakeDB(t, nil)
+	ctx := context.Background()
+
+	preparing := make(chan struct{})
+	release := make(chan struct{})
+	fdb.prepare = func(string) {
+		close(preparing)
+		<-release
+	}
+
+	s := NewQueryStore()
+	if err := s.LoadFromString("users.sql", "-- name: get-user\nSELECT * FROM users WHERE id = :id\n"); err != nil {
+		t.Fatal(err)
+	}
+
+	prepared := make(chan error)
+	go func() {
+		_, err := s.Prepare(ctx, db, "get-user")
+		prepared <- err
+	}()
+	<-preparing
+
+	// the store stays readable and writable while the database prepares
+	done := make(chan error)
+	go func() {
+		if err := s.LoadFromString("orders.sql", "-- name: list-orders\nSELECT * FROM orders\n"); err != nil {
+			done <- err
+			return
+		}
+		_, err := s.Query("list-orders")
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("using the store while preparing: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("store blocked while preparing a statement")
+	}
+
+	close(release)
+	if err := <-prepared; err != nil {
+		t.Fatalf("Prepare: %v", err)
+	}
+}
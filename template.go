@@ -0,0 +1,74 @@
+package queries
+
+import (
+	"fmt"
+	"strings"
+	"text/template"
+)
+
+// parseTemplate parses the raw query as a text/template when the query is
+// marked with "template: true"
+func (q *Query) parseTemplate(opts options) error {
+	enabled, _, err := q.GetMetadataBool("template")
+	if err != nil {
+		return err
+	}
+	if !enabled {
+		return nil
+	}
+
+	tmpl, err := template.New(q.Name).Option("missingkey=error").Parse(q.Raw)
+	if err != nil {
+		return fmt.Errorf("Query '%s' is not a valid template: %v", q.Name, err)
+	}
+
+	q.template = tmpl
+	q.templateOpts = opts
+
+	return nil
+}
+
+// IsTemplate reports whether the query is a text/template, see Render
+func (q *Query) IsTemplate() bool {
+	return q.template != nil
+}
+
+// RenderQuery executes the template of the query with data and parses the
+// output as a new query. Parameters are detected after rendering, so only
+// the ones in the rendered branches are mapped and Prepare of the returned
+// query builds the matching arguments. Queries that are not templates are
+// returned as is.
+func (q *Query) RenderQuery(data interface{}) (*Query, error) {
+	if q.template == nil {
+		return q, nil
+	}
+
+	var b strings.Builder
+	if err := q.template.Execute(&b, data); err != nil {
+		return nil, fmt.Errorf("Error rendering query '%s': %v", q.Name, err)
+	}
+
+	rendered, err := newQuery(q.Name, b.String(), q.templateOpts)
+	if err != nil {
+		return nil, err
+	}
+	rendered.Path = q.Path
+	rendered.Aliases = q.Aliases
+	rendered.ParamTypes = q.ParamTypes
+	rendered.Metadata = q.Metadata
+	rendered.metadataOriginal = q.metadataOriginal
+	rendered.Expect = q.Expect
+
+	return rendered, nil
+}
+
+// Render executes the template of the query with data and returns the
+// rendered statement, see RenderQuery for the arguments
+func (q *Query) Render(data interface{}) (string, error) {
+	rendered, err := q.RenderQuery(data)
+	if err != nil {
+		return "", err
+	}
+
+	return rendered.SQL(), nil
+}
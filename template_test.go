@@ -0,0 +1,83 @@
+package queries
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRender(t *testing.T) {
+	content := "-- name: list-users\n-- template: true\nSELECT * FROM users\nWHERE org_id = :org_id\n{{if .Active}}AND active = :active{{end}}\nORDER BY id\n"
+
+	s := NewQueryStore()
+	if err := s.LoadFromString("users.sql", content); err != nil {
+		t.Fatalf("LoadFromString: %v", err)
+	}
+	q := s.MustHaveQuery("list-users")
+	if !q.IsTemplate() {
+		t.Fatalf("query not detected as template")
+	}
+
+	args := map[string]interface{}{"org_id": 1, "active": true}
+
+	testCases := []struct {
+		name         string
+		data         interface{}
+		expectedSQL  string
+		expectedArgs []interface{}
+	}{
+		{
+			name:         "filter on",
+			data:         map[string]bool{"Active": true},
+			expectedSQL:  "SELECT * FROM users\nWHERE org_id = $1\nAND active = $2\nORDER BY id",
+			expectedArgs: []interface{}{1, true},
+		},
+		{
+			name:         "filter off",
+			data:         map[string]bool{"Active": false},
+			expectedSQL:  "SELECT * FROM users\nWHERE org_id = $1\n\nORDER BY id",
+			expectedArgs: []interface{}{1},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			sql, err := q.Render(tc.data)
+			if err != nil {
+				t.Fatalf("Render: %v", err)
+			}
+			if sql != tc.expectedSQL {
+				t.Errorf("Render: got %q, expected %q", sql, tc.expectedSQL)
+			}
+
+			rendered, err := q.RenderQuery(tc.data)
+			if err != nil {
+				t.Fatalf("RenderQuery: %v", err)
+			}
+			if prepared := rendered.Prepare(args); !reflect.DeepEqual(prepared, tc.expectedArgs) {
+				t.Errorf("Prepare: got %v, expected %v", prepared, tc.expectedArgs)
+			}
+		})
+	}
+}
+
+func TestRenderErrors(t *testing.T) {
+	s := NewQueryStore()
+	if err := s.LoadFromString("users.sql", "-- name: broken\n-- template: true\nSELECT {{if .A}} 1\n"); err == nil {
+		t.Errorf("expected error for unclosed action")
+	}
+
+	if err := s.LoadFromString("users.sql", "-- name: list-users\n-- template: true\nSELECT * FROM users {{.Filter}}\n"); err != nil {
+		t.Fatalf("LoadFromString: %v", err)
+	}
+	if _, err := s.MustHaveQuery("list-users").Render(map[string]string{}); err == nil {
+		t.Errorf("expected error for missing key")
+	}
+
+	plain, err := ParseQuery("plain", "SELECT {{.A}} FROM users WHERE id = :id")
+	if err != nil {
+		t.Fatalf("NewQuery: %v", err)
+	}
+	if sql, err := plain.Render(nil); err != nil || sql != plain.SQL() {
+		t.Errorf("Render of a plain query: got %q, %v", sql, err)
+	}
+}
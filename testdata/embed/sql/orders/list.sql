@@ -0,0 +1,2 @@
+-- name: list-orders
+SELECT * FROM orders WHERE user_id = :user_id
@@ -0,0 +1,2 @@
+-- name: get-user
+SELECT * FROM users WHERE id = :id
@@ -0,0 +1,152 @@
+package queries
+
+import (
+	"context"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// Watch reloads the .sql files in dir and its subdirectories whenever they
+// change, until ctx is cancelled. A changed file replaces the queries
+// previously loaded from it, see RemoveByPath, and a removed file drops
+// them. Files are not loaded up front; call LoadFromDir with the same dir
+// first. Errors are passed to the WithWatchErrorHandler callback and keep
+// the previously loaded queries of the file, as does emptying the file.
+func (s *QueryStore) Watch(ctx context.Context, dir string) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer watcher.Close()
+
+	if err := watchDirs(watcher, dir); err != nil {
+		return err
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return nil
+			}
+			s.handleWatchEvent(watcher, dir, event)
+
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return nil
+			}
+			s.watchError("", err)
+		}
+	}
+}
+
+// watchDirs adds dir and all its subdirectories to the watcher
+func watchDirs(watcher *fsnotify.Watcher, dir string) error {
+	return filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if info.IsDir() {
+			return watcher.Add(path)
+		}
+
+		return nil
+	})
+}
+
+func (s *QueryStore) handleWatchEvent(watcher *fsnotify.Watcher, dir string, event fsnotify.Event) {
+	path := event.Name
+
+	if event.Has(fsnotify.Create) {
+		if info, err := os.Stat(path); err == nil && info.IsDir() {
+			if err := watchDirs(watcher, path); err != nil {
+				s.watchError(path, err)
+			}
+			return
+		}
+	}
+
+	if !s.opts.sqlFile(path) {
+		return
+	}
+
+	switch {
+	case event.Has(fsnotify.Write) || event.Has(fsnotify.Create):
+		rel, _ := filepath.Rel(dir, filepath.Dir(path))
+		if err := s.reloadFile(path, s.namespace(filepath.ToSlash(rel))); err != nil {
+			s.watchError(path, err)
+		}
+
+	case event.Has(fsnotify.Remove) || event.Has(fsnotify.Rename):
+		s.RemoveByPath(path)
+	}
+}
+
+// reloadFile replaces the queries loaded from path. The file is parsed
+// before anything is removed and the queries are swapped under a single
+// lock, so readers never miss them and a broken file keeps its old
+// queries. An empty file is skipped: saving in place truncates the file
+// before writing it, and the write is reported as a separate event.
+func (s *QueryStore) reloadFile(path, namespace string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if info, err := file.Stat(); err != nil || info.Size() == 0 {
+		return err
+	}
+
+	newQueries, scanWarnings, err := s.parseQueries(path, namespace, file)
+	if err != nil {
+		return err
+	}
+	names, warnings, err := s.checkParsed(newQueries, scanWarnings)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	old := make(map[string]*Query)
+	for name, q := range s.queries {
+		if q.Path == path {
+			old[name] = q
+			s.delete(name)
+		}
+	}
+	s.invalidateNames()
+
+	if err := s.insertAll(names, newQueries); err != nil {
+		for name, q := range old {
+			s.insert(name, q)
+		}
+		return err
+	}
+	s.warnings = append(s.warnings, warnings...)
+
+	return s.resolveIncludesLocked()
+}
+
+func (s *QueryStore) watchError(path string, err error) {
+	if s.opts.watchErrorHandler != nil {
+		s.opts.watchErrorHandler(path, err)
+		return
+	}
+
+	if path == "" {
+		log.Printf("queries: watch: %v", err)
+		return
+	}
+
+	log.Printf("queries: watch: reloading '%s': %v", path, err)
+}
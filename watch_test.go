@@ -0,0 +1,124 @@
+package queries
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// waitFor rewrites the file in place until cond holds, as the watcher may
+// not be registered yet when the first write happens
+func waitFor(t *testing.T, path, content string, cond func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for reload of %s", path)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func TestWatch(t *testing.T) {
+	dir := t.TempDir()
+	path := writeSQLFile(t, dir, "users.sql", "-- name: get-user\nSELECT 1\n")
+
+	var mu sync.Mutex
+	var watchErrs []string
+	s := NewQueryStore(WithWatchErrorHandler(func(path string, err error) {
+		mu.Lock()
+		watchErrs = append(watchErrs, path)
+		mu.Unlock()
+	}))
+	if err := s.LoadFromDir(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- s.Watch(ctx, dir)
+	}()
+
+	waitFor(t, path, "-- name: list-users\nSELECT 2\n", func() bool {
+		return s.Has("list-users")
+	})
+	if s.Has("get-user") {
+		t.Errorf("get-user still loaded after the file changed")
+	}
+
+	// a broken file keeps the queries loaded before
+	waitFor(t, path, "-- name: broken\nSELECT :a, $1\n", func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(watchErrs) > 0
+	})
+	if !s.Has("list-users") {
+		t.Errorf("list-users dropped after a failed reload")
+	}
+	mu.Lock()
+	if watchErrs[0] != path {
+		t.Errorf("error handler: got path %q, expected %q", watchErrs[0], path)
+	}
+	mu.Unlock()
+
+	// new files are picked up as well
+	added := filepath.Join(dir, "items.sql")
+	waitFor(t, added, "-- name: list-items\nSELECT 3\n", func() bool {
+		return s.Has("list-items")
+	})
+
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Watch: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Watch did not stop after cancel")
+	}
+}
+
+func TestReloadFile(t *testing.T) {
+	dir := t.TempDir()
+	users := writeSQLFile(t, dir, "users.sql", "-- name: get-user\nSELECT 1\n")
+	writeSQLFile(t, dir, "orders.sql", "-- name: list-orders\nSELECT 2\n")
+
+	s := NewQueryStore()
+	if err := s.LoadFromDir(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	// the truncation of an in-place save
+	writeSQLFile(t, dir, "users.sql", "")
+	if err := s.reloadFile(users, ""); err != nil {
+		t.Errorf("reloading an empty file: %v", err)
+	}
+	if !s.Has("get-user") {
+		t.Errorf("get-user dropped after reloading an empty file")
+	}
+
+	writeSQLFile(t, dir, "users.sql", "-- name: list-orders\nSELECT 3\n")
+	if err := s.reloadFile(users, ""); err == nil {
+		t.Errorf("expected a name collision error")
+	}
+	if !s.Has("get-user") || s.MustHaveQuery("list-orders").Raw != "SELECT 2" {
+		t.Errorf("queries changed after a failed reload: %v", s.QueryNames())
+	}
+
+	writeSQLFile(t, dir, "users.sql", "-- name: list-users\nSELECT 4\n")
+	if err := s.reloadFile(users, ""); err != nil {
+		t.Fatalf("reloadFile: %v", err)
+	}
+	if names := s.QueryNames(); !reflect.DeepEqual(names, []string{"list-orders", "list-users"}) {
+		t.Errorf("QueryNames: got %v", names)
+	}
+}